@@ -2,6 +2,10 @@
 
 package api
 
+import (
+	"encoding/json"
+)
+
 // 返回 Cookie 密钥对
 func APIBoxSessionGetKeyAction(c *Context) interface{} {
 	store, err := c.App.SessionStore()
@@ -11,3 +15,19 @@ func APIBoxSessionGetKeyAction(c *Context) interface{} {
 
 	return store.GetKeyPairs()
 }
+
+// 返回 OpenAPI 3 文档
+func APIBoxSpecAction(c *Context) interface{} {
+	specBytes, err := json.MarshalIndent(c.App.BuildSpec(), "", "    ")
+	if err != nil {
+		return c.Error.New(ErrorInternalError, "BuildSpecFailed").SetMessage(err.Error())
+	}
+
+	// 直接输出文档，不使用 ACTION/CODE/DATA 格式
+	c.CloseResponse()
+	w := c.Response()
+	w.Header().Set("Server", c.App.ServerName)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(specBytes)
+	return nil
+}
@@ -3,43 +3,64 @@
 package api
 
 import (
-	"net"
+	stdcontext "context"
+	"crypto/tls"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-apibox/config"
 	"github.com/go-apibox/filter"
 	"github.com/go-apibox/logging"
 	"github.com/go-apibox/session"
-	"github.com/go-apibox/utils"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 )
 
 type App struct {
-	Name                string
-	Host                string
-	Addr                string
-	ServerName          string
-	Router              *mux.Router
-	Config              *config.Config
-	DB                  *DbManager
-	Model               *ModelManager
-	Error               *ErrorManager
-	Logger              *Logger
-	Routes              []*Route
-	Hooks               map[string][]ActionFunc
-	UnderMaintenance    bool
-	Middlewares         map[string]negroni.Handler
-	middlewareNames     []string //确保顺序
-	listenEventHandlers []ListenEventHandler
-	sockClosed          chan bool
-	HTTPServer          *http.Server
+	Name                  string
+	Host                  string
+	Addr                  string
+	ServerName            string
+	Router                *mux.Router
+	Config                *config.Config
+	DB                    *DbManager
+	Model                 *ModelManager
+	Error                 *ErrorManager
+	Logger                *Logger
+	Routes                []*Route
+	Hooks                 map[string][]ActionFunc
+	UnderMaintenance      bool
+	Middlewares           map[string]negroni.Handler
+	middlewareNames       []string //确保顺序
+	listenEventHandlers   []ListenEventHandler
+	listenerEventHandlers []ListenerEventHandler
+	shutdownHandlers      []ShutdownHandler
+	healthChecks          []*healthCheck
+	metrics               *metrics // 未启用时为nil
+	tracer                *tracer  // 未启用时为nil
+	spanExporter          SpanExporter
+	shutdownOnce          sync.Once
+	shutdownDone          chan bool // 关闭完成后close
+	shutdownErr           error
+	listeners             []*appListener    // 运行中的监听
+	inheritedEnvs         map[string]string // 读取后清空的环境变量，平滑重启时传给新进程
+	supervisorPid         int               // 启动应用的父进程
+	certReloader          *certReloader     // 证书热加载
+	certReloaderOnce      sync.Once
+	sniCerts              []*sniCert // 按SNI选择的证书
+	sniCertsErr           error
+	sniCertsOnce          sync.Once
+	trustedProxies        *trustedProxies // 运行后加载
+	HTTPServer            *http.Server
 }
 
 // NewApp create an application with config file: config/app.yaml.
@@ -81,24 +102,28 @@ func newApp(cfg *config.Config) *App {
 	actionBlacklist := cfg.GetDefaultStringArray("api.log.actions.blacklist", []string{})
 	logger.SetWhiteList(actionWhitelist)
 	logger.SetBlackList(actionBlacklist)
+	logger.SetFormat(cfg.GetDefaultString("api.log.format", "text"))
 
 	router := mux.NewRouter()
 
 	app := &App{
-		Name:                appName,
-		ServerName:          "apibox/" + appName,
-		Router:              router,
-		Config:              cfg,
-		DB:                  dbm,
-		Model:               NewModelManager(),
-		Error:               em,
-		Logger:              logger,
-		Hooks:               make(map[string][]ActionFunc),
-		UnderMaintenance:    false,
-		Middlewares:         map[string]negroni.Handler{},
-		middlewareNames:     []string{},
-		listenEventHandlers: []ListenEventHandler{},
-		sockClosed:          nil,
+		Name:                  appName,
+		ServerName:            "apibox/" + appName,
+		Router:                router,
+		Config:                cfg,
+		DB:                    dbm,
+		Model:                 NewModelManager(),
+		Error:                 em,
+		Logger:                logger,
+		Hooks:                 make(map[string][]ActionFunc),
+		UnderMaintenance:      false,
+		Middlewares:           map[string]negroni.Handler{},
+		middlewareNames:       []string{},
+		listenEventHandlers:   []ListenEventHandler{},
+		listenerEventHandlers: []ListenerEventHandler{},
+		shutdownHandlers:      []ShutdownHandler{},
+		shutdownDone:          make(chan bool),
+		inheritedEnvs:         map[string]string{},
 	}
 
 	// 加载各模块
@@ -111,6 +136,7 @@ func newApp(cfg *config.Config) *App {
 		host = cfg.GetDefaultString("app.host", "")
 	} else {
 		os.Setenv("APP_HOST", "") // 读取完立即清空，防止被子进程继承
+		app.inheritedEnvs["APP_HOST"] = host
 	}
 	app.Host = host
 	addr := os.Getenv("APP_ADDR")
@@ -121,28 +147,9 @@ func newApp(cfg *config.Config) *App {
 		}
 	} else {
 		os.Setenv("APP_ADDR", "") // 读取完立即清空，防止被子进程继承
+		app.inheritedEnvs["APP_ADDR"] = addr
 	}
-	if strings.IndexByte(addr, ':') == -1 {
-		// unix domain socket
-
-		// remove unix domain socket file
-		if fi, err := os.Stat(addr); err == nil {
-			if fi.Mode()&os.ModeType == os.ModeSocket {
-				os.Remove(addr)
-			}
-		}
-
-		if !filepath.IsAbs(addr) {
-			progDir := filepath.Dir(os.Args[0])
-			addr = filepath.Join(progDir, addr)
-		}
-		// auto create addr parent directory if not exist
-		addrDir := filepath.Dir(addr)
-		if _, err := os.Stat(addrDir); err != nil && os.IsNotExist(err) {
-			os.MkdirAll(addrDir, 0755)
-		}
-	}
-	app.Addr = addr
+	app.Addr = normalizeAddr(addr)
 
 	return app
 }
@@ -234,36 +241,72 @@ func (app *App) Run(routes []*Route) error {
 	cfg := app.Config
 	apiPath := cfg.GetDefaultString("api.path", "/")
 
+	// 内置接口
+	if cfg.GetDefaultBool("api.spec.enabled", false) {
+		routes = addBuiltinRoute(routes, NewRoute("APIBox.Spec", APIBoxSpecAction).SetSummary("OpenAPI 3 document"))
+	}
+	if cfg.GetDefaultBool("api.batch.enabled", false) {
+		routes = addBuiltinRoute(routes, NewRoute("APIBox.Batch", APIBoxBatchAction).SetSummary("Batch call actions"))
+	}
+	app.Routes = routes
+
+	// 受信任的代理
+	trustedProxies, err := app.loadTrustedProxies()
+	if err != nil {
+		app.Logger.Critical(err.Error())
+		return err
+	}
+	app.trustedProxies = trustedProxies
+
 	// 注册中间件
 	rec := negroni.NewRecovery()
 	rec.PrintStack = false
-	n := negroni.New(rec, app.Logger)
+	// panic 日志由 Logger 输出，带上请求ID
+	rec.Logger = log.New(io.Discard, "", 0)
+	rec.PanicHandlerFunc = app.Logger.logPanic
+	// 自动加RequestId，在日志之前生成以便日志中输出
+	reqIdMaker := NewRequestIdMaker()
+	reqIdMaker.TrustIncoming = cfg.GetDefaultBool("api.request_id.trust_incoming", false)
+	n := negroni.New(rec, &realIpResolver{trustedProxies}, reqIdMaker, app.Logger)
 	for _, mName := range app.middlewareNames {
 		n.Use(app.Middlewares[mName])
 	}
-	// 自动加RequestId
-	n.Use(NewRequestIdMaker())
 
 	// 注册http handler
 	apiMux := http.NewServeMux()
 	apiMux.HandleFunc(apiPath, app.Route(routes))
+	paths := []string{apiPath}
+	// JSON-RPC 2.0 接口
+	if cfg.GetDefaultBool("api.jsonrpc.enabled", false) {
+		jsonrpcPath := cfg.GetDefaultString("api.jsonrpc.path", "/jsonrpc")
+		if jsonrpcPath != apiPath {
+			apiMux.HandleFunc(jsonrpcPath, newJSONRPCHandler(app, routes))
+			paths = append(paths, jsonrpcPath)
+		}
+	}
 	n.UseHandler(apiMux)
 
+	// Host 由各监听限制，见 listenerHandler
 	router := app.Router
-	if app.Host != "" {
-		router.Host(app.Host).Subrouter().Handle(apiPath, context.ClearHandler(n))
-	} else {
-		router.Handle(apiPath, context.ClearHandler(n))
+	for _, path := range paths {
+		router.Handle(path, context.ClearHandler(n))
+	}
+	// 健康检查接口
+	app.addHealthRoutes(paths)
+	// 监控指标接口
+	app.initMetrics(paths)
+	// 请求追踪
+	if err := app.initTracing(); err != nil {
+		app.Logger.Critical(err.Error())
+		return err
 	}
-
-	// 运行
-	app.Logger.Noticef("listening on %s", app.Addr)
 
 	// 是否禁止应用运行为pid=1（根进程）的子进程
 	var allowWild bool
 	envAllowWild := os.Getenv("APP_ALLOW_WILD")
 	if envAllowWild != "" {
 		os.Setenv("APP_ALLOW_WILD", "") // 读取完立即清空，防止被子进程继承
+		app.inheritedEnvs["APP_ALLOW_WILD"] = envAllowWild
 	}
 	switch envAllowWild {
 	case "0":
@@ -274,53 +317,38 @@ func (app *App) Run(routes []*Route) error {
 		allowWild = cfg.GetDefaultBool("app.allow_wild", false)
 	}
 
+	// 平滑重启产生的新进程，父进程退出后改为检测原父进程
+	app.supervisorPid = os.Getppid()
+	if envPid := os.Getenv("APP_SUPERVISOR_PID"); envPid != "" {
+		os.Setenv("APP_SUPERVISOR_PID", "") // 读取完立即清空，防止被子进程继承
+		if pid, err := strconv.Atoi(envPid); err == nil {
+			app.supervisorPid = pid
+		}
+	}
+
 	if !allowWild {
 		// 定时检测进程是否为野进程
 		go func() {
 			for {
 				time.Sleep(time.Second)
-				if os.Getppid() == 1 {
+				if os.Getppid() == 1 && (app.supervisorPid == 1 || !processExists(app.supervisorPid)) {
 					app.Logger.Fatal("wild process killed")
 				}
 			}
 		}()
 	}
 
-	// 判断是否unix
-	isUnix := strings.IndexByte(app.Addr, ':') == -1
-	tlsEnabled := cfg.GetDefaultBool("app.tls.enabled", false)
-	var certPemBlock, keyPemBlock []byte
-
-	if tlsEnabled {
-		certFile := cfg.GetDefaultString("app.tls.cert", "server.crt")
-		keyFile := cfg.GetDefaultString("app.tls.key", "server.key")
-
-		var err error
-		var autoGenCert bool
-		if certFile == "" || keyFile == "" {
-			autoGenCert = true
-		} else if utils.FileExists(certFile) && utils.FileExists(certFile) {
-			certPemBlock, keyPemBlock, err = loadX509PemBlock(certFile, keyFile)
-			if err != nil {
-				// 解析失败，不停止服务，转而使用自动生成证书
-				app.Logger.Error(err.Error())
-				autoGenCert = true
-			} else {
-				autoGenCert = false
-			}
-		} else {
-			autoGenCert = true
-		}
+	defines, err := app.ListenerDefines()
+	if err != nil {
+		app.Logger.Critical(err.Error())
+		return err
+	}
 
-		if autoGenCert {
-			// 自动生成证书
-			var bindIp string
-			if isUnix {
-				bindIp = "127.0.0.1"
-			} else {
-				bindIp = app.Addr[0:strings.IndexByte(app.Addr, ':')]
-			}
-			certPemBlock, keyPemBlock, err = makeCert(app.Host, bindIp)
+	// 准备TLS配置
+	tlsConfigs := make([]*tls.Config, len(defines))
+	for i, define := range defines {
+		if define.TLS {
+			tlsConfigs[i], err = app.NewTLSConfig(define)
 			if err != nil {
 				app.Logger.Critical(err.Error())
 				return err
@@ -328,35 +356,73 @@ func (app *App) Run(routes []*Route) error {
 		}
 	}
 
-	var appErr error
-	if isUnix {
-		// unix domain socket
-		if tlsEnabled {
-			appErr = app.serveTLSWithPemBlock("unix", app.Addr, router, certPemBlock, keyPemBlock)
-		} else {
-			appErr = app.serve("unix", app.Addr, router)
+	// 监听所有地址，任一失败则关闭已监听的socket
+	app.listeners = make([]*appListener, 0, len(defines))
+	for _, define := range defines {
+		ln, err := app.listen(define)
+		if err != nil {
+			for _, l := range app.listeners {
+				l.ln.Close()
+			}
+			app.listeners = nil
+			return err
 		}
-		if opErr, ok := appErr.(*net.OpError); ok {
-			if opErr.Op == "accept" {
-				// accept 失败，表示socket已断开
-				appErr = nil
+
+		server := &http.Server{Addr: define.Addr, Handler: app.listenerHandler(define, paths)}
+		app.listeners = append(app.listeners, &appListener{define, ln, server})
+	}
+	app.HTTPServer = app.listeners[0].server
+
+	// 平滑重启时通知父进程
+	notifyParentReady()
+
+	// 与unix domain socket统一处理
+	// 当项目多次调用API库时（多个APP），有些APP使用unix domain socket，有些使用 tcp，
+	// 则可能导致使用 tcp 的 APP 不退出（因为signal被其它APP处理）
+	app.signalHandler()
+
+	// 运行
+	errs := make(chan error, len(app.listeners))
+	for i, l := range app.listeners {
+		app.Logger.Noticef("listening on %s", l.define.Addr)
+		go func(l *appListener, tlsConfig *tls.Config) {
+			if tlsConfig != nil {
+				errs <- app.serveTLS(l.server, l.ln, l.define, tlsConfig)
+			} else {
+				errs <- app.serve(l.server, l.ln, l.define)
 			}
+		}(l, tlsConfigs[i])
+	}
+
+	var appErr error
+	for range app.listeners {
+		err := <-errs
+		if err == http.ErrServerClosed {
+			continue
 		}
-	} else {
-		// tcp socket
-		if tlsEnabled {
-			appErr = app.serveTLSWithPemBlock("tcp", app.Addr, router, certPemBlock, keyPemBlock)
-		} else {
-			appErr = app.serve("tcp", app.Addr, router)
+		// 任一监听出错则关闭所有监听
+		if appErr == nil {
+			appErr = err
+			go app.gracefulShutdown()
 		}
 	}
 
-	// 等待连接关闭
-	if app.sockClosed != nil {
-		<-app.sockClosed
+	// 等待请求处理完成
+	<-app.shutdownDone
+	if appErr != nil {
+		return appErr
 	}
+	return app.shutdownErr
+}
 
-	return appErr
+// addBuiltinRoute add the builtin route if the action is not defined in routes.
+func addBuiltinRoute(routes []*Route, route *Route) []*Route {
+	for _, r := range routes {
+		if r.ActionCode == route.ActionCode {
+			return routes
+		}
+	}
+	return append(routes, route)
 }
 
 // InitDb load mysql and sqlite3 config section to app.
@@ -399,6 +465,7 @@ func (app *App) InitDb() {
 	envDb := os.Getenv("APP_DB")
 	if envDb != "" {
 		os.Setenv("APP_DB", "") // 读取完立即清空，防止被子进程继承
+		app.inheritedEnvs["APP_DB"] = envDb
 
 		// 环境变量中指定的DB，格式如：APP_DB="default:test.db:true;log:log.db"
 		if HasDriver("sqlite3") {
@@ -471,7 +538,9 @@ func (app *App) InitDb() {
 func (app *App) InitError() {
 	app.Error.RegisterGroupErrors("global", globalErrorDefines)
 	app.Error.RegisterErrors(appErrorDefines)
+	app.Error.RegisterWords(apiWordMap)
 	app.LoadConfigWords()
+	app.LoadConfigLangFallbacks()
 
 	app.LoadFilterErrors()
 }
@@ -539,6 +608,22 @@ invalid_format:
 	app.Logger.Warning("(api) format error with words config: ignore words config.")
 }
 
+// LoadConfigLangFallbacks load api.lang.fallbacks config section to app.
+// 格式如：api.lang.fallbacks.zh_tw: [zh_cn, en_us]
+func (app *App) LoadConfigLangFallbacks() {
+	langs, err := app.Config.GetSubKeys("api.lang.fallbacks")
+	if err != nil {
+		return
+	}
+	for _, lang := range langs {
+		fallbacks := app.Config.GetDefaultStringArray("api.lang.fallbacks."+lang, []string{})
+		for i, fallback := range fallbacks {
+			fallbacks[i] = normalizeLang(fallback)
+		}
+		app.Error.SetLangFallbacks(normalizeLang(lang), fallbacks)
+	}
+}
+
 // Route return a handler func using by http.HandleFunc.
 func (app *App) Route(routes []*Route) http.HandlerFunc {
 	return newApiHandler(app, routes)
@@ -571,6 +656,21 @@ func (app *App) GetLogger() *logging.Logger {
 	return app.Logger.Logger
 }
 
+// Close close the http server immediately, active connections will be dropped.
+// Shutdown handlers are called with a canceled context and databases are closed
+// as Shutdown does. Use Shutdown to close gracefully.
 func (app *App) Close() error {
-	return app.HTTPServer.Close()
+	var err error
+	// 关闭连接后正在进行的 Shutdown 也会尽快结束
+	for _, server := range app.httpServers() {
+		if e := server.Close(); e != nil {
+			err = e
+		}
+	}
+	app.shutdownOnce.Do(func() {
+		ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+		cancel()
+		app.finishShutdown(ctx)
+	})
+	return err
 }
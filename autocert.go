@@ -0,0 +1,257 @@
+// 自动生成证书
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-apibox/pki"
+	"github.com/go-apibox/utils"
+)
+
+// autoCert 返回自动生成的证书，配置项：
+//
+//	app.tls.auto_cert.dir: 证书保存目录，再次启动时复用，为空时不保存，默认为 autocert
+//	app.tls.auto_cert.renew_before: 证书在过期前多久重新生成，默认为 720h
+//	app.tls.auto_cert.check_interval: 运行中检查证书是否需要重新生成的间隔，默认为 1h
+//	app.tls.auto_cert.ca: 是否在保存目录中生成本地CA（ca.crt）签发证书，默认使用 go-apibox/pki 的CA
+//
+// 证书的域名或IP与当前配置不一致时重新生成。
+func (app *App) autoCert(define *ListenerDefine) (certPemBlock, keyPemBlock []byte, err error) {
+	cfg := app.Config
+
+	var bindIp string
+	if define.Network() == "unix" {
+		bindIp = "127.0.0.1"
+	} else {
+		bindIp, _, err = net.SplitHostPort(define.Addr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dir, err := app.autoCertDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir == "" {
+		return makeCert(define.Host, bindIp)
+	}
+
+	dnsNames, ipAddresses, err := certNames(define.Host, bindIp)
+	if err != nil {
+		return nil, nil, err
+	}
+	renewBefore := getConfigDuration(cfg, "app.tls.auto_cert.renew_before", 30*24*time.Hour)
+
+	caCert, caKey := pki.RootCert, pki.RootKey
+	if cfg.GetDefaultBool("app.tls.auto_cert.ca", false) {
+		caCert, caKey, err = app.localCA(dir, renewBefore)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 复用已生成的证书
+	name := autoCertName(define.Addr)
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if utils.FileExists(certFile) && utils.FileExists(keyFile) {
+		certPemBlock, keyPemBlock, err = loadX509PemBlock(certFile, keyFile)
+		if err == nil {
+			cert, err := parseCertPemBlock(certPemBlock)
+			if err == nil && cert.CheckSignatureFrom(caCert) == nil &&
+				time.Now().Add(renewBefore).Before(cert.NotAfter) &&
+				certNamesEqual(cert, dnsNames, ipAddresses) {
+				return certPemBlock, keyPemBlock, nil
+			}
+		}
+	}
+
+	certPemBlock, keyPemBlock, err = createCert(caCert, caKey, dnsNames, ipAddresses)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, keyPemBlock, 0600); err != nil {
+		app.Logger.Warningf("(api) save auto cert failed: %s", err.Error())
+	} else if err := ioutil.WriteFile(certFile, certPemBlock, 0644); err != nil {
+		app.Logger.Warningf("(api) save auto cert failed: %s", err.Error())
+	} else {
+		app.Logger.Noticef("(api) auto cert generated: %s", certFile)
+	}
+	return certPemBlock, keyPemBlock, nil
+}
+
+// autoCertDir 返回自动生成证书的保存目录，不保存时返回空字符串
+func (app *App) autoCertDir() (string, error) {
+	dir := app.Config.GetDefaultString("app.tls.auto_cert.dir", "autocert")
+	if dir == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(dir) {
+		progDir := filepath.Dir(os.Args[0])
+		dir = filepath.Join(progDir, dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// loadLocalCA 读取或生成 app.tls.auto_cert.ca 启用的本地CA证书
+func (app *App) loadLocalCA() (*x509.Certificate, *rsa.PrivateKey, error) {
+	cfg := app.Config
+	if !cfg.GetDefaultBool("app.tls.auto_cert.ca", false) {
+		return nil, nil, errors.New("local ca requires app.tls.auto_cert.ca")
+	}
+	dir, err := app.autoCertDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir == "" {
+		return nil, nil, errors.New("local ca requires app.tls.auto_cert.dir")
+	}
+	renewBefore := getConfigDuration(cfg, "app.tls.auto_cert.renew_before", 30*24*time.Hour)
+	return app.localCA(dir, renewBefore)
+}
+
+// localCA 读取或生成本地CA证书，保存为 ca.crt 和 ca.key
+func (app *App) localCA(dir string, renewBefore time.Duration) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+	if utils.FileExists(certFile) && utils.FileExists(keyFile) {
+		certPemBlock, keyPemBlock, err := loadX509PemBlock(certFile, keyFile)
+		if err == nil {
+			cert, certErr := parseCertPemBlock(certPemBlock)
+			key, keyErr := parseKeyPemBlock(keyPemBlock)
+			if certErr == nil && keyErr == nil && time.Now().Add(renewBefore).Before(cert.NotAfter) {
+				return cert, key, nil
+			}
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   app.Name + " Local CA",
+			Organization: []string{app.Name},
+		},
+		NotBefore:             time.Now().AddDate(0, 0, -1), // 往前一天，防止PC时间不准确
+		NotAfter:              time.Now().AddDate(20, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPemBlock := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPemBlock, 0600); err != nil {
+		return nil, nil, err
+	}
+	certPemBlock := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	if err := ioutil.WriteFile(certFile, certPemBlock, 0644); err != nil {
+		return nil, nil, err
+	}
+	app.Logger.Noticef("(api) local ca generated: %s", certFile)
+	return cert, key, nil
+}
+
+// autoCertName 根据监听地址生成证书文件名，如：0.0.0.0:443 => auto_0.0.0.0_443
+func autoCertName(addr string) string {
+	if strings.IndexByte(addr, ':') == -1 {
+		addr = strings.TrimSuffix(filepath.Base(addr), filepath.Ext(addr))
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, addr)
+	return "auto_" + strings.Trim(name, "_")
+}
+
+// certNamesEqual 判断证书的域名和IP是否与指定的一致
+func certNamesEqual(cert *x509.Certificate, dnsNames []string, ipAddresses []net.IP) bool {
+	if !stringSetEqual(cert.DNSNames, dnsNames) {
+		return false
+	}
+	certIps := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		certIps = append(certIps, ip.String())
+	}
+	ips := make([]string, 0, len(ipAddresses))
+	for _, ip := range ipAddresses {
+		ips = append(ips, ip.String())
+	}
+	return stringSetEqual(certIps, ips)
+}
+
+// stringSetEqual 判断两个字符串列表去重后是否相同
+func stringSetEqual(a, b []string) bool {
+	a = uniqueSorted(a)
+	b = uniqueSorted(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueSorted(ss []string) []string {
+	rt := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if !strSliceContains(rt, s) {
+			rt = append(rt, s)
+		}
+	}
+	sort.Strings(rt)
+	return rt
+}
+
+func parseCertPemBlock(certPemBlock []byte) (*x509.Certificate, error) {
+	p, _ := pem.Decode(certPemBlock)
+	if p == nil {
+		return nil, errors.New("not pem format of certificate")
+	}
+	return x509.ParseCertificate(p.Bytes)
+}
+
+func parseKeyPemBlock(keyPemBlock []byte) (*rsa.PrivateKey, error) {
+	p, _ := pem.Decode(keyPemBlock)
+	if p == nil {
+		return nil, errors.New("not pem format of private key")
+	}
+	return x509.ParsePKCS1PrivateKey(p.Bytes)
+}
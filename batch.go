@@ -0,0 +1,253 @@
+// 批量调用
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// batchCall 批量调用中的单个调用，格式如：{"action": "User.Get", "params": {"UserId": 1}}
+type batchCall struct {
+	action string
+	params map[string]interface{}
+}
+
+// batchResponseWriter 子调用的输出，子调用的结果由调用方统一输出
+type batchResponseWriter struct {
+	header http.Header
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *batchResponseWriter) WriteHeader(int) {
+}
+
+// 批量调用多个接口
+// 调用列表可以是JSON请求体的顶层数组，也可以是参数 Calls（JSON数组）
+// 参数 Parallel=true 时并行执行，Transaction=true 时所有调用共用一个数据库事务，
+// 任一调用失败则回滚，此时成功的调用结果替换为 RolledBack 错误，
+// 启用事务时总是顺序执行
+// 注意：List 的计数及查询使用独立的会话，事务中不能读取到之前调用未提交的写入
+func APIBoxBatchAction(c *Context) interface{} {
+	params := c.NewParams()
+	params.Add("Parallel", boolFilter{})
+	params.Add("Transaction", boolFilter{})
+	if err := params.Parse(); err != nil {
+		return err
+	}
+	parallel, _ := params.Get("Parallel").(bool)
+	transaction, _ := params.Get("Transaction").(bool)
+
+	calls, err := getBatchCalls(c)
+	if err != nil {
+		return c.Error.New(ErrorInvalidParam, "Calls")
+	}
+	if calls == nil {
+		return c.Error.New(ErrorMissingParam, "Calls")
+	}
+	maxCalls := c.App.Config.GetDefaultInt("api.batch.max_calls", 50)
+	if len(calls) > maxCalls {
+		return c.Error.New(ErrorQuotaExceed, "Calls")
+	}
+
+	actionMap := buildActionMap(c.App.Routes)
+	results := make([]interface{}, len(calls))
+
+	if transaction {
+		db, err := getDB(c)
+		if err != nil {
+			c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
+			return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
+		}
+		defer closeDB(c, db)
+
+		session := c.NewSession(db)
+		defer session.Close()
+		if err := session.Begin(); err != nil {
+			c.Logger().Errorf("(dbop error): [SessionBeginFailed] %s", err.Error())
+			return c.Error.New(ErrorInternalError, "SessionBeginFailed").SetMessage("Session Begin Failed.")
+		}
+
+		allOk := true
+		for i, call := range calls {
+			results[i] = runBatchCall(c, actionMap, call, func(ctx *Context) {
+				// SQL日志及追踪归属于子调用
+				session.Context(ctx.sessionContext())
+				ctx.Set("dbop_session", session)
+			})
+			if !isSuccessResult(results[i]) {
+				allOk = false
+			}
+		}
+
+		session.Context(c.sessionContext())
+		if !allOk {
+			session.Rollback()
+			// 成功的调用已回滚，不能返回成功结果
+			for i, call := range calls {
+				if isSuccessResult(results[i]) {
+					results[i] = makeResult(call.action, c.Error.NewGroupError("global", errorBatchRolledBack))
+				}
+			}
+			return results
+		}
+		if err := session.Commit(); err != nil {
+			c.Logger().Errorf("(dbop error): [SessionCommitFailed] %s", err.Error())
+			return c.Error.New(ErrorInternalError, "SessionCommitFailed").SetMessage("Session Commit Failed.")
+		}
+		return results
+	}
+
+	if parallel {
+		maxParallel := c.App.Config.GetDefaultInt("api.batch.max_parallel", 8)
+		if maxParallel < 1 {
+			maxParallel = 1
+		}
+		sem := make(chan struct{}, maxParallel)
+		var wg sync.WaitGroup
+		for i, call := range calls {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, call *batchCall) {
+				defer wg.Done()
+				results[i] = runBatchCall(c, actionMap, call, nil)
+				<-sem
+			}(i, call)
+		}
+		wg.Wait()
+		return results
+	}
+
+	for i, call := range calls {
+		results[i] = runBatchCall(c, actionMap, call, nil)
+	}
+	return results
+}
+
+// isSuccessResult 判断调用是否成功
+func isSuccessResult(result interface{}) bool {
+	_, ok := result.(*SuccessResult)
+	return ok
+}
+
+// getBatchCalls 读取调用列表，未指定时返回nil
+func getBatchCalls(c *Context) ([]*batchCall, error) {
+	var raw interface{}
+	if list, ok := c.Input.GetRaw().([]interface{}); ok {
+		raw = list
+	} else if obj := c.Input.GetRawObject(); obj != nil && obj["Calls"] != nil {
+		raw = obj["Calls"]
+	} else if c.Input.Has("Calls") {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(c.Input.Get("Calls"))))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, nil
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("calls should be an array")
+	}
+	calls := make([]*batchCall, 0, len(list))
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("call should be an object")
+		}
+		action, _ := obj["action"].(string)
+		if action == "" {
+			return nil, fmt.Errorf("action of call is missing")
+		}
+		callParams := map[string]interface{}{}
+		if obj["params"] != nil {
+			callParams, ok = obj["params"].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("params of call should be an object")
+			}
+		}
+		calls = append(calls, &batchCall{action, callParams})
+	}
+	return calls, nil
+}
+
+// runBatchCall 运行单个调用，返回 *SuccessResult, *ErrorResult 或 *ErrorResultWithData
+func runBatchCall(c *Context, actionMap map[string]*Route, call *batchCall, setup func(ctx *Context)) interface{} {
+	route, ok := actionMap[call.action]
+	if !ok || call.action == "APIBox.Batch" {
+		return makeResult(call.action, c.Error.NewGroupError("global", errorActionNotExist))
+	}
+
+	// 继承批量调用的语言
+	data := callAction(c.App, c.Request(), route, call.params, c.Input.Get("api_lang"), setup)
+	return makeResult(call.action, data)
+}
+
+// callAction 以指定参数在新的运行环境中调用接口，经过完整的 BeforeAction/AfterAction 流程
+// 运行环境的请求复制自 r，请求参数替换为 params
+func callAction(app *App, r *http.Request, route *Route, params map[string]interface{},
+	apiLang string, setup func(ctx *Context)) (data interface{}) {
+	action := route.ActionCode
+
+	form := make(url.Values, len(params)+2)
+	for key, val := range params {
+		if strVals, ok := jsonFormValues(val); ok {
+			form[key] = strVals
+		}
+	}
+	form.Set("api_action", action)
+	if apiLang != "" && form.Get("api_lang") == "" {
+		form.Set("api_lang", apiLang)
+	}
+
+	sr := r.Clone(r.Context())
+	sr.Header.Del("Content-Type")
+	sr.Body = http.NoBody
+	sr.ContentLength = 0
+	sr.URL.RawQuery = ""
+	sr.Form = form
+	sr.PostForm = form
+	sr.MultipartForm = nil
+
+	ctx, err := NewContext(app, &batchResponseWriter{make(http.Header)}, sr)
+	if err != nil {
+		return app.Error.New(ErrorInternalError, "CallFailed").SetMessage(err.Error())
+	}
+	defer ctx.Clear()
+	// 子调用使用相同的请求ID
+	if t := getRequestTrace(r); t != nil {
+		ctx.Set("request_trace", t)
+	}
+	if span := getCurrentSpan(r); span != nil {
+		ctx.Set("trace_span", span)
+	}
+	// 子请求没有经过中间件，使用原请求解析的客户端IP
+	ctx.Set("real_ip", NewInput(r).GetRealIp())
+	// 对象等非标量参数通过 Params.RawData 读取，过滤时转为JSON字符串
+	ctx.Input.raw = params
+
+	defer func() {
+		if r := recover(); r != nil {
+			ctx.Logger().Errorf("(call error): [%s] %v", action, r)
+			data = ctx.Error.New(ErrorInternalError, "CallFailed").SetMessage(fmt.Sprint(r))
+		}
+	}()
+
+	if setup != nil {
+		setup(ctx)
+	}
+	return runAction(app, ctx, route)
+}
@@ -0,0 +1,192 @@
+// 批量调用测试
+
+package api
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"xorm.io/xorm"
+)
+
+// txTestDriver 只记录事务操作的数据库驱动
+type txTestDriver struct {
+	mutex sync.Mutex
+	ops   []string
+}
+
+func (d *txTestDriver) Open(string) (driver.Conn, error) { return &txTestConn{d}, nil }
+
+func (d *txTestDriver) log(op string) {
+	d.mutex.Lock()
+	d.ops = append(d.ops, op)
+	d.mutex.Unlock()
+}
+
+func (d *txTestDriver) reset() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ops := d.ops
+	d.ops = nil
+	return ops
+}
+
+type txTestConn struct{ d *txTestDriver }
+
+func (c *txTestConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *txTestConn) Close() error                        { return nil }
+func (c *txTestConn) Begin() (driver.Tx, error)           { c.d.log("begin"); return c, nil }
+func (c *txTestConn) Commit() error                       { c.d.log("commit"); return nil }
+func (c *txTestConn) Rollback() error                     { c.d.log("rollback"); return nil }
+
+// 注册为 mysql 驱动，以使用 xorm 的 mysql 方言
+var batchTestDriver = &txTestDriver{}
+
+func init() {
+	sql.Register("mysql", batchTestDriver)
+}
+
+func newBatchTestHandler(t *testing.T) func(values url.Values) []map[string]interface{} {
+	t.Helper()
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := xorm.NewEngine("mysql", "test:test@tcp(127.0.0.1:3306)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DB.mysqlDBMap["default"] = &MySQLDB{Engine: engine}
+
+	routes := []*Route{
+		NewRoute("Test.Echo", func(c *Context) interface{} {
+			// 事务中的调用共用同一会话
+			return map[string]interface{}{"Value": c.Input.Get("Value"), "InTx": getTxSession(c) != nil}
+		}),
+		NewRoute("Test.Fail", func(c *Context) interface{} {
+			return c.Error.New(ErrorInvalidParam, "Value")
+		}),
+		NewRoute("APIBox.Batch", APIBoxBatchAction),
+	}
+	app.SetRoutes(routes)
+	handler := app.Route(routes)
+
+	return func(values url.Values) []map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/?api_action=APIBox.Batch&"+values.Encode(), nil))
+		var res struct {
+			CODE string
+			DATA []map[string]interface{}
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("invalid response: %s", w.Body.String())
+		}
+		if res.CODE != "ok" {
+			t.Fatalf("batch failed: %s", w.Body.String())
+		}
+		return res.DATA
+	}
+}
+
+func batchCodes(results []map[string]interface{}) string {
+	codes := make([]string, 0, len(results))
+	for _, r := range results {
+		codes = append(codes, r["CODE"].(string))
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestBatch(t *testing.T) {
+	call := newBatchTestHandler(t)
+	calls := `[{"action":"Test.Echo","params":{"Value":"a"}},` +
+		`{"action":"Test.Fail"},` +
+		`{"action":"Test.None"},` +
+		`{"action":"Test.Echo","params":{"Value":"b"}}]`
+
+	for _, parallel := range []string{"false", "true"} {
+		results := call(url.Values{"Calls": {calls}, "Parallel": {parallel}})
+		if got, want := batchCodes(results), "ok,InvalidParam:Value,ActionNotExist,ok"; got != want {
+			t.Errorf("parallel=%s: codes = %s, want %s", parallel, got, want)
+			continue
+		}
+		if v := results[3]["DATA"].(map[string]interface{})["Value"]; v != "b" {
+			t.Errorf("parallel=%s: Value = %v, want b", parallel, v)
+		}
+		if inTx := results[0]["DATA"].(map[string]interface{})["InTx"]; inTx != false {
+			t.Errorf("parallel=%s: call should not run in transaction", parallel)
+		}
+	}
+	if ops := batchTestDriver.reset(); len(ops) != 0 {
+		t.Errorf("db ops without transaction = %v", ops)
+	}
+}
+
+func TestBatchTransaction(t *testing.T) {
+	call := newBatchTestHandler(t)
+	batchTestDriver.reset()
+
+	// 全部成功时提交
+	results := call(url.Values{
+		"Transaction": {"true"},
+		"Calls":       {`[{"action":"Test.Echo","params":{"Value":"a"}},{"action":"Test.Echo"}]`},
+	})
+	if got := batchCodes(results); got != "ok,ok" {
+		t.Errorf("codes = %s, want ok,ok", got)
+	}
+	if inTx := results[0]["DATA"].(map[string]interface{})["InTx"]; inTx != true {
+		t.Error("call should run in transaction")
+	}
+	if ops := strings.Join(batchTestDriver.reset(), ","); ops != "begin,commit" {
+		t.Errorf("db ops = %s, want begin,commit", ops)
+	}
+
+	// 任一调用失败时回滚，成功的调用返回 RolledBack
+	results = call(url.Values{
+		"Transaction": {"true"},
+		"Calls":       {`[{"action":"Test.Echo"},{"action":"Test.Fail"},{"action":"Test.Echo"}]`},
+	})
+	if got, want := batchCodes(results), "RolledBack,InvalidParam:Value,RolledBack"; got != want {
+		t.Errorf("codes = %s, want %s", got, want)
+	}
+	if ops := strings.Join(batchTestDriver.reset(), ","); ops != "begin,rollback" {
+		t.Errorf("db ops = %s, want begin,rollback", ops)
+	}
+}
+
+func TestBatchInvalidCalls(t *testing.T) {
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := app.Route([]*Route{NewRoute("APIBox.Batch", APIBoxBatchAction)})
+	cases := []struct {
+		calls string
+		code  string
+	}{
+		{"", "MissingParam:Calls"},
+		{`{"action":"Test.Echo"}`, "InvalidParam:Calls"},
+		{`[1]`, "InvalidParam:Calls"},
+		{`[{"params":{}}]`, "InvalidParam:Calls"},
+		{`[{"action":"Test.Echo","params":[]}]`, "InvalidParam:Calls"},
+		{`[` + strings.Repeat(`{"action":"Test.Echo"},`, 50) + `{"action":"Test.Echo"}]`, "QuotaExceed:Calls"},
+	}
+	for _, c := range cases {
+		values := url.Values{"api_action": {"APIBox.Batch"}}
+		if c.calls != "" {
+			values.Set("Calls", c.calls)
+		}
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/?"+values.Encode(), nil))
+		if body := w.Body.String(); !strings.Contains(body, `"CODE":"`+c.code+`"`) {
+			t.Errorf("Calls=%s: body = %s, want %s", c.calls, body, c.code)
+		}
+	}
+}
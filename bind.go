@@ -0,0 +1,394 @@
+// 参数绑定
+
+package api
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-apibox/filter"
+	"github.com/go-apibox/utils"
+)
+
+// 参数绑定的标签格式，多个项以空格分隔：
+// `param:"name:UserName required default:10 min:1 max:100 enum:a,b,c regexp:^[a-z]+$"`
+// name     参数名，默认为字段名，为"-"时忽略该字段
+// required 必填参数
+// default  默认值
+// min/max  数值的大小，字符串的长度，文件的大小（可带单位，如：2MB）
+// enum     允许的值，以逗号分隔，文件时为允许的类型
+// regexp   字符串需匹配的正则，必须放在最后，其后内容均作为正则
+type bindField struct {
+	index      []int
+	paramName  string
+	required   bool
+	hasDefault bool
+	defaultVal string
+	min        string
+	max        string
+	enum       []string
+	regexp     string
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	ipType         = reflect.TypeOf(net.IP{})
+	uploadFileType = reflect.TypeOf(&UploadFile{})
+)
+
+// Bind declare params according to the param tags of struct fields, parse the
+// params and fill the parsed values into the struct.
+// v should be a pointer to a struct.
+// The parsed values are saved with param names as keys as Parse does, the
+// struct fields are not written back to params. To pass params to Create/Update
+// after binding, the param names should be the same as the model field names,
+// which is the default when name is not specified in the tag.
+func (p *Params) Bind(v interface{}) *Error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return p.Error.New(ErrorInternalError, "WrongParamType").SetMessage("Expected a pointer to a struct!")
+	}
+	structVal := rv.Elem()
+
+	fields, err := getBindFields(structVal.Type(), nil)
+	if err != nil {
+		return p.Error.New(ErrorInternalError, "InvalidBindTag").SetMessage(err.Error())
+	}
+
+	for _, bf := range fields {
+		field := structVal.Type().FieldByIndex(bf.index)
+		filters, err := bf.filters(field.Type)
+		if err != nil {
+			return p.Error.New(ErrorInternalError, "InvalidBindTag").SetMessage(field.Name + ": " + err.Error())
+		}
+		p.Add(bf.paramName, filters...)
+	}
+
+	if apiErr := p.Parse(); apiErr != nil {
+		return apiErr
+	}
+
+	for _, bf := range fields {
+		val := p.Get(bf.paramName)
+		if val == nil {
+			continue
+		}
+
+		fieldVal := structVal.FieldByIndex(bf.index)
+		if !setBindValue(fieldVal, val) {
+			return p.Error.New(ErrorInternalError, "WrongParamType").SetMessage("Can not bind param " + bf.paramName + "!")
+		}
+	}
+
+	return nil
+}
+
+// getBindFields return all the fields to bind, anonymous struct will be expanded.
+func getBindFields(t reflect.Type, index []int) ([]*bindField, error) {
+	fields := make([]*bindField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			subFields, err := getBindFields(field.Type, fieldIndex)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, subFields...)
+			continue
+		}
+
+		// 未导出字段
+		if field.PkgPath != "" {
+			continue
+		}
+
+		bf, err := parseBindTag(field.Tag.Get("param"))
+		if err != nil {
+			return nil, errors.New(field.Name + ": " + err.Error())
+		}
+		if bf.paramName == "-" {
+			continue
+		}
+		if bf.paramName == "" {
+			bf.paramName = field.Name
+		}
+		bf.index = fieldIndex
+		fields = append(fields, bf)
+	}
+	return fields, nil
+}
+
+func parseBindTag(tag string) (*bindField, error) {
+	bf := new(bindField)
+	tag = strings.TrimSpace(tag)
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "regexp:") {
+			item, tag = tag, ""
+		} else if pos := strings.IndexByte(tag, ' '); pos != -1 {
+			item, tag = tag[:pos], strings.TrimSpace(tag[pos+1:])
+		} else {
+			item, tag = tag, ""
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		name := parts[0]
+		if name == "required" {
+			bf.required = true
+			continue
+		}
+		if len(parts) == 1 {
+			return nil, errors.New("invalid tag item: " + item)
+		}
+		val := parts[1]
+
+		switch name {
+		case "name":
+			bf.paramName = val
+		case "default":
+			bf.hasDefault = true
+			bf.defaultVal = val
+		case "min":
+			bf.min = val
+		case "max":
+			bf.max = val
+		case "enum":
+			bf.enum = strings.Split(val, ",")
+		case "regexp":
+			bf.regexp = val
+		default:
+			return nil, errors.New("invalid tag item: " + item)
+		}
+	}
+	return bf, nil
+}
+
+// filters return the filters of field with type t.
+func (bf *bindField) filters(t reflect.Type) ([]filter.Filter, error) {
+	// 先设置默认值，有默认值的必填参数可以不传
+	filters := make([]filter.Filter, 0, 3)
+	if bf.hasDefault {
+		filters = append(filters, filter.Default(bf.defaultVal))
+	}
+	if bf.required {
+		filters = append(filters, filter.Required())
+	}
+
+	if t.Kind() == reflect.Ptr && t != uploadFileType {
+		t = t.Elem()
+	}
+
+	var f filter.Filter
+	var err error
+	switch {
+	case t == timeType:
+		f, err = bf.timeFilter()
+	case t == ipType:
+		f = filter.IP()
+	case t == uploadFileType || t == reflect.SliceOf(uploadFileType):
+		f, err = bf.fileFilter()
+	case t.Kind() == reflect.Bool:
+		f = boolFilter{}
+	case t.Kind() == reflect.Slice:
+		f, err = bf.kindFilter(t.Elem().Kind(), true)
+	default:
+		f, err = bf.kindFilter(t.Kind(), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(filters, f), nil
+}
+
+func (bf *bindField) timeFilter() (filter.Filter, error) {
+	f := filter.Time()
+	if bf.min != "" {
+		f.StartFrom(bf.min)
+	}
+	if bf.max != "" {
+		f.EndTo(bf.max)
+	}
+	return f, nil
+}
+
+func (bf *bindField) fileFilter() (filter.Filter, error) {
+	f := File()
+	if bf.min != "" {
+		size, err := utils.ParseBytes(bf.min)
+		if err != nil {
+			return nil, err
+		}
+		f.MinSize(int64(size))
+	}
+	if bf.max != "" {
+		size, err := utils.ParseBytes(bf.max)
+		if err != nil {
+			return nil, err
+		}
+		f.MaxSize(int64(size))
+	}
+	if bf.enum != nil {
+		f.Types(bf.enum...)
+	}
+	return f, nil
+}
+
+// kindFilters 各类型的过滤器，第一个用于单个值，第二个用于切片（集合）
+var kindFilters = map[reflect.Kind][2]func() filter.Filter{
+	reflect.String:  {func() filter.Filter { return filter.String() }, func() filter.Filter { return filter.StringSet() }},
+	reflect.Int:     {func() filter.Filter { return filter.Int() }, func() filter.Filter { return filter.IntSet() }},
+	reflect.Int32:   {func() filter.Filter { return filter.Int32() }, func() filter.Filter { return filter.Int32Set() }},
+	reflect.Int64:   {func() filter.Filter { return filter.Int64() }, func() filter.Filter { return filter.Int64Set() }},
+	reflect.Uint:    {func() filter.Filter { return filter.Uint() }, func() filter.Filter { return filter.UintSet() }},
+	reflect.Uint32:  {func() filter.Filter { return filter.Uint32() }, func() filter.Filter { return filter.Uint32Set() }},
+	reflect.Uint64:  {func() filter.Filter { return filter.Uint64() }, func() filter.Filter { return filter.Uint64Set() }},
+	reflect.Float32: {func() filter.Filter { return filter.Float32() }, nil},
+	reflect.Float64: {func() filter.Filter { return filter.Float64() }, nil},
+}
+
+// kindFilter 返回类型的过滤器，通过反射调用过滤器的方法设置限制：
+// 单个值为 Min/Max/In/Match，集合为 ItemMin/ItemMax/ItemIn/ItemMatch，字符串的 Min/Max 为 MinLen/MaxLen
+// 限制的值转换为方法参数的类型
+func (bf *bindField) kindFilter(kind reflect.Kind, isSet bool) (filter.Filter, error) {
+	typeName, i, prefix := kind.String(), 0, ""
+	if isSet {
+		typeName, i, prefix = "[]"+typeName, 1, "Item"
+	}
+	newFilters, ok := kindFilters[kind]
+	if !ok || newFilters[i] == nil {
+		return nil, errors.New("unsupported type: " + typeName)
+	}
+	f := newFilters[i]()
+	fv := reflect.ValueOf(f)
+
+	minName, maxName := "Min", "Max"
+	if kind == reflect.String {
+		minName, maxName = "MinLen", "MaxLen"
+	}
+	if bf.min != "" {
+		if err := callBindMethod(fv.MethodByName(prefix+minName), bf.min); err != nil {
+			return nil, err
+		}
+	}
+	if bf.max != "" {
+		if err := callBindMethod(fv.MethodByName(prefix+maxName), bf.max); err != nil {
+			return nil, err
+		}
+	}
+	if bf.enum != nil {
+		in := fv.MethodByName(prefix + "In")
+		set := reflect.MakeSlice(in.Type().In(0), len(bf.enum), len(bf.enum))
+		for j, s := range bf.enum {
+			if err := parseBindValue(s, set.Index(j)); err != nil {
+				return nil, err
+			}
+		}
+		in.Call([]reflect.Value{set})
+	}
+	// 只有字符串支持正则
+	if match := fv.MethodByName(prefix + "Match"); bf.regexp != "" && match.IsValid() {
+		match.Call([]reflect.Value{reflect.ValueOf(bf.regexp)})
+	}
+	return f, nil
+}
+
+// callBindMethod 将 s 转换为方法参数的类型后调用方法
+func callBindMethod(method reflect.Value, s string) error {
+	arg := reflect.New(method.Type().In(0)).Elem()
+	if err := parseBindValue(s, arg); err != nil {
+		return err
+	}
+	method.Call([]reflect.Value{arg})
+	return nil
+}
+
+// parseBindValue 将 s 按 v 的类型解析后设置到 v
+func parseBindValue(s string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return errors.New("unsupported type: " + v.Type().String())
+	}
+	return nil
+}
+
+// boolFilter 布尔值过滤器，接受 1/0、true/false 等
+type boolFilter struct{}
+
+func (f boolFilter) Run(paramName string, paramValue interface{}) (interface{}, *filter.Error) {
+	switch val := paramValue.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return val, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(val)); err == nil {
+			return b, nil
+		}
+	}
+	return nil, filter.NewError(filter.ErrorInvalidParam, paramName, "NotBool")
+}
+
+// setBindValue 将过滤后的值设置到字段，必要时转换指针
+func setBindValue(fieldVal reflect.Value, val interface{}) bool {
+	rv := reflect.ValueOf(val)
+	t := fieldVal.Type()
+
+	if rv.Type().AssignableTo(t) {
+		fieldVal.Set(rv)
+		return true
+	}
+	// 过滤器返回指针，如：*time.Time, *net.IP
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Type().AssignableTo(t) {
+		fieldVal.Set(rv.Elem())
+		return true
+	}
+	// 指针字段
+	if t.Kind() == reflect.Ptr {
+		pv := reflect.New(t.Elem())
+		if setBindValue(pv.Elem(), val) {
+			fieldVal.Set(pv)
+			return true
+		}
+		return false
+	}
+	// 单个文件与文件数组
+	switch v := val.(type) {
+	case *UploadFile:
+		if t == reflect.SliceOf(uploadFileType) {
+			fieldVal.Set(reflect.ValueOf([]*UploadFile{v}))
+			return true
+		}
+	case []*UploadFile:
+		if t == uploadFileType && len(v) > 0 {
+			fieldVal.Set(reflect.ValueOf(v[0]))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// 参数绑定测试
+
+package api
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bindTestBase struct {
+	Page int `param:"name:PageNo default:1 min:1"`
+}
+
+type bindTestReq struct {
+	bindTestBase
+	Name   string   `param:"name:UserName required min:2 max:5 regexp:^[a-z]+$"`
+	Kind   string   `param:"required default:user enum:user,admin"`
+	Age    int32    `param:"min:1 max:100"`
+	Size   uint64   `param:"max:10"`
+	Score  float32  `param:"enum:1.5,2.5"`
+	Rate   float64  `param:"min:0 max:1"`
+	Ids    []int64  `param:"min:1"`
+	Tags   []string `param:"enum:a,b"`
+	Active bool
+	Limit  *uint32
+	Skip   string `param:"name:-"`
+	hidden string
+}
+
+func newBindTestParams(t *testing.T, values url.Values) *Params {
+	t.Helper()
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewParams(values, app.Error)
+}
+
+func TestParamsBind(t *testing.T) {
+	var req bindTestReq
+	params := newBindTestParams(t, url.Values{
+		"UserName": {"alice"},
+		"Age":      {"30"},
+		"Size":     {"10"},
+		"Score":    {"2.5"},
+		"Rate":     {"0.5"},
+		"Ids":      {"1,2,3"},
+		"Tags":     {"a,b"},
+		"Active":   {"true"},
+		"Limit":    {"7"},
+		"Skip":     {"x"},
+	})
+	if err := params.Bind(&req); err != nil {
+		t.Fatal(err)
+	}
+
+	limit := uint32(7)
+	want := bindTestReq{
+		bindTestBase{1}, "alice", "user", 30, 10, 2.5, 0.5,
+		[]int64{1, 2, 3}, []string{"a", "b"}, true, &limit, "", "",
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("Bind() = %+v, want %+v", req, want)
+	}
+	// 解析结果以参数名保存
+	if v := params.Get("UserName"); v != "alice" {
+		t.Errorf("params.Get(UserName) = %v", v)
+	}
+	if params.Has("Name") || params.Has("Skip") {
+		t.Error("struct field names should not be saved in params")
+	}
+}
+
+func TestParamsBindErrors(t *testing.T) {
+	cases := []struct {
+		values url.Values
+		code   string
+	}{
+		{url.Values{}, "MissingParam:UserName"},
+		{url.Values{"UserName": {"a"}}, "InvalidParam:UserName:"},
+		{url.Values{"UserName": {"abcdef"}}, "InvalidParam:UserName:"},
+		{url.Values{"UserName": {"Alice"}}, "InvalidParam:UserName:"},
+		{url.Values{"UserName": {"alice"}, "Kind": {"root"}}, "InvalidParam:Kind:"},
+		{url.Values{"UserName": {"alice"}, "Age": {"0"}}, "InvalidParam:Age:"},
+		{url.Values{"UserName": {"alice"}, "Age": {"x"}}, "InvalidParam:Age:"},
+		{url.Values{"UserName": {"alice"}, "Size": {"11"}}, "InvalidParam:Size:"},
+		{url.Values{"UserName": {"alice"}, "Score": {"2"}}, "InvalidParam:Score:"},
+		{url.Values{"UserName": {"alice"}, "Rate": {"1.5"}}, "InvalidParam:Rate:"},
+		{url.Values{"UserName": {"alice"}, "Ids": {"1,0"}}, "InvalidParam:Ids:"},
+		{url.Values{"UserName": {"alice"}, "Tags": {"a,c"}}, "InvalidParam:Tags:"},
+		{url.Values{"UserName": {"alice"}, "Active": {"yes"}}, "InvalidParam:Active:"},
+		{url.Values{"UserName": {"alice"}, "PageNo": {"0"}}, "InvalidParam:PageNo:"},
+	}
+	for _, c := range cases {
+		var req bindTestReq
+		err := newBindTestParams(t, c.values).Bind(&req)
+		if err == nil {
+			t.Errorf("Bind(%v) should fail with %s", c.values, c.code)
+			continue
+		}
+		if !strings.HasPrefix(err.Code, c.code) {
+			t.Errorf("Bind(%v) error = %s, want %s", c.values, err.Code, c.code)
+		}
+	}
+}
+
+func TestParamsBindInvalidTarget(t *testing.T) {
+	cases := []struct {
+		name string
+		v    interface{}
+	}{
+		{"not pointer", bindTestReq{}},
+		{"not struct", new(string)},
+		{"bad tag", &struct {
+			A string `param:"unknown:1"`
+		}{}},
+		{"bad min", &struct {
+			A int `param:"min:x"`
+		}{}},
+		{"overflow", &struct {
+			A int32 `param:"max:4294967296"`
+		}{}},
+		{"unsupported", &struct{ A map[string]string }{}},
+		{"unsupported set", &struct{ A []float64 }{}},
+	}
+	for _, c := range cases {
+		err := newBindTestParams(t, url.Values{}).Bind(c.v)
+		if err == nil || !strings.HasPrefix(err.Code, "InternalError") {
+			t.Errorf("%s: Bind() error = %v, want InternalError", c.name, err)
+		}
+	}
+}
+
+func TestParseBindTag(t *testing.T) {
+	bf, err := parseBindTag("name:Name required default:a b min:1 regexp:^[a-z ]+$")
+	if err == nil {
+		t.Fatalf("default with space should fail, got %+v", bf)
+	}
+
+	bf, err = parseBindTag("name:Name required default:a enum:a,b min:1 max:9 regexp:^[a-z ]+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &bindField{nil, "Name", true, true, "a", "1", "9", []string{"a", "b"}, "^[a-z ]+$"}
+	if !reflect.DeepEqual(bf, want) {
+		t.Errorf("parseBindTag() = %+v, want %+v", bf, want)
+	}
+}
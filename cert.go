@@ -1,6 +1,7 @@
 package api
 
 import (
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -14,8 +15,17 @@ import (
 // 生成指定域名列表和IP的证书
 // 多个域名或IP有,分隔
 func makeCert(domains, ip string) (certPemBlock, keyPemBlock []byte, err error) {
-	dnsNames := []string{}
-	ipAddresses := []net.IP{}
+	dnsNames, ipAddresses, err := certNames(domains, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	return createCert(pki.RootCert, pki.RootKey, dnsNames, ipAddresses)
+}
+
+// 返回证书中的域名列表和IP列表
+func certNames(domains, ip string) (dnsNames []string, ipAddresses []net.IP, err error) {
+	dnsNames = []string{}
+	ipAddresses = []net.IP{}
 
 	if domains != "" {
 		ds := strings.Split(domains, ",")
@@ -29,8 +39,9 @@ func makeCert(domains, ip string) (certPemBlock, keyPemBlock []byte, err error)
 			}
 		}
 	}
-	if ip != "*" && ip != "0.0.0.0" && ip != "" {
-		ipAddresses = append(ipAddresses, net.ParseIP(ip))
+	// 绑定所有地址时（如：0.0.0.0, ::）不加入证书
+	if bindIp := net.ParseIP(ip); bindIp != nil && !bindIp.IsUnspecified() {
+		ipAddresses = append(ipAddresses, bindIp)
 	}
 	if len(dnsNames) == 0 && len(ipAddresses) == 0 {
 		// 如果都没有设置，则取所有网卡IP
@@ -55,6 +66,12 @@ func makeCert(domains, ip string) (certPemBlock, keyPemBlock []byte, err error)
 		}
 	}
 
+	return dnsNames, ipAddresses, nil
+}
+
+// 使用指定的CA证书签发证书
+func createCert(parentCert *x509.Certificate, parentKey *rsa.PrivateKey,
+	dnsNames []string, ipAddresses []net.IP) (certPemBlock, keyPemBlock []byte, err error) {
 	// 如果未绑定域名，则 Common Name 设置为IP，否则 Common Name 为第一个域名
 	var commonName string
 	if len(dnsNames) > 0 {
@@ -63,7 +80,10 @@ func makeCert(domains, ip string) (certPemBlock, keyPemBlock []byte, err error)
 		commonName = ipAddresses[0].String()
 	}
 
-	certBytes, privKey, err := pki.CreateX509Cert(pki.RootCert, pki.RootKey, commonName, dnsNames, ipAddresses)
+	certBytes, privKey, err := pki.CreateX509Cert(parentCert, parentKey, commonName, dnsNames, ipAddresses)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var pemcert = &pem.Block{
 		Type:  "CERTIFICATE",
@@ -0,0 +1,192 @@
+// 证书热加载
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-apibox/utils"
+)
+
+// certReloader 从文件加载证书，文件变化或收到 SIGHUP 信号时重新加载
+// 自动生成的证书定时检查，即将过期时重新生成
+// 新证书加载失败时继续使用旧证书
+type certReloader struct {
+	app      *App
+	certFile string
+	keyFile  string
+	define   *ListenerDefine // 不为nil时使用自动生成的证书
+
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	notAfter time.Time
+}
+
+// newCertReloader 加载证书，加载失败时返回错误
+func newCertReloader(app *App, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{app: app, certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newAutoCertReloader 加载监听的自动生成证书，加载失败时返回错误
+func newAutoCertReloader(app *App, define *ListenerDefine) (*certReloader, error) {
+	r := &certReloader{app: app, certFile: define.Addr, define: define}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// reload 重新加载证书
+func (r *certReloader) reload() error {
+	var certPemBlock, keyPemBlock []byte
+	var err error
+	var modTime time.Time
+	if r.define != nil {
+		certPemBlock, keyPemBlock, err = r.app.autoCert(r.define)
+	} else {
+		modTime = r.fileModTime()
+		certPemBlock, keyPemBlock, err = loadX509PemBlock(r.certFile, r.keyFile)
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPemBlock, keyPemBlock)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.notAfter = leaf.NotAfter
+	r.mutex.Unlock()
+	return nil
+}
+
+// fileModTime 返回证书和私钥文件中较新的修改时间
+func (r *certReloader) fileModTime() time.Time {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime
+}
+
+// reloadIfModified 文件有变化时重新加载
+func (r *certReloader) reloadIfModified() {
+	r.mutex.RLock()
+	modTime := r.modTime
+	r.mutex.RUnlock()
+	newModTime := r.fileModTime()
+	if newModTime.Equal(modTime) {
+		return
+	}
+
+	// 加载失败时也记录修改时间，避免重复报错
+	r.mutex.Lock()
+	r.modTime = newModTime
+	r.mutex.Unlock()
+	r.reloadAndLog()
+}
+
+// renewIfExpiring 自动生成的证书在 app.tls.auto_cert.renew_before 内过期时重新生成
+func (r *certReloader) renewIfExpiring() {
+	r.mutex.RLock()
+	notAfter := r.notAfter
+	r.mutex.RUnlock()
+	renewBefore := getConfigDuration(r.app.Config, "app.tls.auto_cert.renew_before", 30*24*time.Hour)
+	if time.Now().Add(renewBefore).Before(notAfter) {
+		return
+	}
+	r.reloadAndLog()
+}
+
+func (r *certReloader) reloadAndLog() {
+	if err := r.reload(); err != nil {
+		r.app.Logger.Errorf("(api) reload cert failed, keep using the old one: %s", err.Error())
+		return
+	}
+	r.app.Logger.Noticef("(api) cert reloaded: %s", r.certFile)
+}
+
+// watch 收到 SIGHUP 信号时重新加载，app.tls.reload_interval 大于0时定时检查文件变化
+// 自动生成的证书按 app.tls.auto_cert.check_interval 定时检查是否过期，默认为 1h
+func (r *certReloader) watch() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	interval := getConfigDuration(r.app.Config, "app.tls.reload_interval", 0)
+	if r.define != nil {
+		interval = getConfigDuration(r.app.Config, "app.tls.auto_cert.check_interval", time.Hour)
+	}
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case <-sigc:
+			r.app.Logger.Notice("Caught signal SIGHUP: reloading cert.")
+			r.reloadAndLog()
+		case <-tick:
+			if r.define != nil {
+				r.renewIfExpiring()
+			} else {
+				r.reloadIfModified()
+			}
+		case <-r.app.shutdownDone:
+			return
+		}
+	}
+}
+
+// getCertReloader 返回 app.tls.cert/app.tls.key 的证书加载器，未配置或加载失败时返回nil
+func (app *App) getCertReloader() *certReloader {
+	app.certReloaderOnce.Do(func() {
+		cfg := app.Config
+		certFile := cfg.GetDefaultString("app.tls.cert", "server.crt")
+		keyFile := cfg.GetDefaultString("app.tls.key", "server.key")
+		if certFile == "" || keyFile == "" || !utils.FileExists(certFile) || !utils.FileExists(keyFile) {
+			return
+		}
+
+		r, err := newCertReloader(app, certFile, keyFile)
+		if err != nil {
+			// 解析失败，不停止服务，转而使用自动生成证书
+			app.Logger.Error(err.Error())
+			return
+		}
+		app.certReloader = r
+		go r.watch()
+	})
+	return app.certReloader
+}
@@ -0,0 +1,87 @@
+// 客户端证书认证
+
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+)
+
+// ClientCert 已验证的客户端证书信息
+type ClientCert struct {
+	Subject        string // 如：CN=client,O=AppNode User,C=CN
+	CommonName     string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	Fingerprint    string // SHA-256 指纹，小写十六进制
+	Certificate    *x509.Certificate
+}
+
+// newClientCert 从证书生成客户端证书信息
+func newClientCert(cert *x509.Certificate) *ClientCert {
+	sum := sha256.Sum256(cert.Raw)
+	return &ClientCert{
+		cert.Subject.String(),
+		cert.Subject.CommonName,
+		cert.DNSNames,
+		cert.IPAddresses,
+		cert.EmailAddresses,
+		cert.URIs,
+		hex.EncodeToString(sum[:]),
+		cert,
+	}
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"none":            tls.NoClientCert,
+	"require":         tls.RequireAndVerifyClientCert,
+	"verify_if_given": tls.VerifyClientCertIfGiven,
+}
+
+// setClientAuth 根据 app.tls.client_auth 和 app.tls.client_ca 设置客户端证书认证
+func (app *App) setClientAuth(config *tls.Config) error {
+	cfg := app.Config
+
+	authName := cfg.GetDefaultString("app.tls.client_auth", "none")
+	authType, ok := tlsClientAuthTypes[authName]
+	if !ok {
+		return fmt.Errorf("unknown tls client auth: %s", authName)
+	}
+	if authType == tls.NoClientCert {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	caFile := cfg.GetDefaultString("app.tls.client_ca", "")
+	switch caFile {
+	case "":
+		return fmt.Errorf("app.tls.client_ca is required when app.tls.client_auth is %s", authName)
+	case "local":
+		// go-apibox/pki 的CA私钥是公开的，不能用于认证客户端，只能使用本地生成的CA
+		caCert, _, err := app.loadLocalCA()
+		if err != nil {
+			return err
+		}
+		pool.AddCert(caCert)
+	default:
+		caPemBlock, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(caPemBlock) {
+			return fmt.Errorf("no certificate found in client ca: %s", caFile)
+		}
+	}
+
+	config.ClientAuth = authType
+	config.ClientCAs = pool
+	return nil
+}
@@ -3,7 +3,9 @@
 package api
 
 import (
-	// "mime"
+	stdcontext "context"
+	"io"
+	"mime"
 	"net/http"
 	"strings"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/gorilla/websocket"
 	"xorm.io/xorm"
+	"xorm.io/xorm/log"
 )
 
 type Context struct {
@@ -47,40 +50,66 @@ func fixHeader(h http.Header) {
 	}
 }
 
+// isJSONRequest 判断请求体是否为JSON格式，如：application/json、application/vnd.api+json
+func isJSONRequest(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 func NewContext(app *App, w http.ResponseWriter, r *http.Request) (*Context, error) {
 	fixHeader(r.Header)
 
-	// 仅支持从GET/POST参数中获取Action
-	// contentType := r.Header.Get("Content-Type")
-	// if contentType != "" {
-	// 	mediaType, _, err := mime.ParseMediaType(contentType)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	if strings.HasPrefix(mediaType, "multipart/") {
-	// 		if err := r.ParseMultipartForm(0xffffffff); err != nil {
-	// 			return nil, err
-	// 		}
-	// 	}
-	// }
-
 	if err := r.ParseForm(); err != nil {
 		return nil, err
 	}
 
-	input := &Input{r}
-	apiLang := input.Get("api_lang")
-	if apiLang != "" {
-		app.Error.SetLang(apiLang)
-	} else {
-		// 重置为应用默认配置
-		app.Error.SetLang(app.Config.GetDefaultString("api.default.lang", "en_us"))
+	input := NewInput(r)
+
+	if isJSONRequest(r) {
+		// JSON请求体
+		maxSize := getConfigBytes(app.Config, "api.json.max_size", 10<<20)
+		if err := input.parseJSONBody(maxSize); err != nil {
+			return nil, err
+		}
+	} else if isMultipartRequest(r) {
+		// 上传文件，URL或请求体中指定了action时使用该action的上传限制
+		limit := app.uploadLimit(r.Form.Get("api_action"))
+		memSize := getConfigBytes(app.Config, "api.upload.memory_size", 1<<20)
+		tempDir := app.Config.GetDefaultString("api.upload.temp_dir", "")
+		if err := input.parseMultipartBody(limit, app.uploadLimit, memSize, tempDir); err != nil {
+			input.RemoveFiles()
+			return nil, err
+		}
 	}
 
-	context := &Context{app, input, &Output{w}, app.DB, app.Model, app.Error}
+	em := app.requestErrorManager(r, input.Get("api_lang"))
+
+	context := &Context{app, input, &Output{w}, app.DB, app.Model, em}
 	return context, nil
 }
 
+// requestErrorManager 返回请求使用的错误管理器
+// 每个请求使用独立的错误管理器，避免并发请求间互相修改语言
+// 未指定 api_lang 时，根据 Accept-Language 协商，仍无匹配则使用应用默认语言
+// api_lang 与协商的语言一样转为框架的格式，如：zh-TW => zh_tw
+func (app *App) requestErrorManager(r *http.Request, apiLang string) *ErrorManager {
+	apiLang = normalizeLang(apiLang)
+	if apiLang == "" {
+		apiLang = app.Error.NegotiateLang(r.Header.Get("Accept-Language"))
+	}
+	return app.Error.WithLang(apiLang)
+}
+
 // Response return current request.
 func (c *Context) Request() *http.Request {
 	return c.Input.Request
@@ -91,6 +120,67 @@ func (c *Context) Response() http.ResponseWriter {
 	return c.Output.ResponseWriter
 }
 
+// ClientCert return the verified client certificate, nil if not provided.
+func (c *Context) ClientCert() *ClientCert {
+	r := c.Request()
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return newClientCert(r.TLS.VerifiedChains[0][0])
+}
+
+// RequestID return the id of current request, which is also set in X-Request-Id response header.
+func (c *Context) RequestID() string {
+	if t := getRequestTrace(c.Input.Request); t != nil {
+		return t.requestId
+	}
+	return ""
+}
+
+// Logger return the logger of app which prefixes each line with the request id.
+// The framework logs of request, such as dbop errors, access logs and panics, are prefixed too,
+// but lines logged through App.Logger directly are not.
+func (c *Context) Logger() *RequestLogger {
+	return newRequestLogger(c.App.Logger, c.RequestID())
+}
+
+// NewSession return a new session of engine, the SQL logs of session are prefixed with the request id,
+// and the SQL statements are traced as child spans of current span if tracing is enabled.
+func (c *Context) NewSession(engine *xorm.Engine) *xorm.Session {
+	return engine.NewSession().Context(c.sessionContext())
+}
+
+// sessionContext 返回数据库会话使用的 context
+func (c *Context) sessionContext() stdcontext.Context {
+	ctx := c.Input.Request.Context()
+	if reqId := c.RequestID(); reqId != "" {
+		ctx = stdcontext.WithValue(ctx, log.SessionIDKey, reqId)
+	}
+	if c.App.tracer != nil {
+		ctx = stdcontext.WithValue(ctx, traceContextKey{}, c)
+	}
+	return ctx
+}
+
+// NewRequest return a http request for calling other services,
+// the X-Request-Id and W3C traceparent headers are set to propagate the request id and trace.
+func (c *Context) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.Input.Request.Context(), method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if t := getRequestTrace(c.Input.Request); t != nil {
+		req.Header.Set("X-Request-Id", t.requestId)
+		// 以当前 Span 作为下游请求的 parent-id
+		if span := c.currentSpan(); span != nil {
+			req.Header.Set("traceparent", "00-"+t.traceId+"-"+span.SpanId+"-"+t.flags)
+		} else {
+			req.Header.Set("traceparent", t.traceparent())
+		}
+	}
+	return req, nil
+}
+
 // Session return the session with specified name.
 func (c *Context) Session(sessionName string) (*Session, error) {
 	store, err := c.App.SessionStore()
@@ -109,10 +199,31 @@ func (c *Context) Session(sessionName string) (*Session, error) {
 	return &Session{*session, c.Output.ResponseWriter}, nil
 }
 
-// Clear removes all values stored for a given request.
-// This is usually called by a handler wrapper to clean up request variables at the end of a request lifetime. See ClearHandler().
+// NewParams return a new Params object with the request params.
 func (c *Context) NewParams() *Params {
-	return NewParams(c.Input.GetForm(), c.Error)
+	params := NewParams(c.Input.GetForm(), c.Error)
+	if obj := c.Input.GetRawObject(); obj != nil {
+		params.RawData = obj
+	}
+	params.RawFiles = c.Input.files
+	return params
+}
+
+// BindParams create params from request and bind them into v, see Params.Bind.
+// The params are also returned so that they can be passed to Create/Update.
+func (c *Context) BindParams(v interface{}) (*Params, *Error) {
+	params := c.NewParams()
+	if err := params.Bind(v); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// RouteParams return the params parsed by the rules declared with Route.SetParams
+// or Route.SetBind, nil if the route has no param rules.
+func (c *Context) RouteParams() *Params {
+	params, _ := c.Get("route_params").(*Params)
+	return params
 }
 
 // Clear removes all values stored for a given request.
@@ -3,6 +3,7 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"os"
@@ -88,6 +89,7 @@ func (dbm *DbManager) AddMysql(dbAlias, protocol, address, user, passwd, dbName
 	} else {
 		engine.SetMaxIdleConns(maxIdleConns)
 		engine.SetMaxOpenConns(maxOpenConns)
+		engine.AddHook(sqlTraceHook{})
 		dbm.mysqlDBMap[dbAlias] = &MySQLDB{
 			Engine:       engine,
 			Protocol:     protocol,
@@ -122,6 +124,7 @@ func (dbm *DbManager) SetMysql(dbAlias string, engine *xorm.Engine) {
 	defer dbm.mutex.Unlock()
 
 	if db, has := dbm.mysqlDBMap[dbAlias]; has {
+		engine.AddHook(sqlTraceHook{})
 		db.Engine = engine
 	}
 }
@@ -177,6 +180,9 @@ func (dbm *DbManager) AddSqlite3(dbAlias, sqliteDb string, persistent bool) erro
 		if engine, err := xorm.NewEngine("sqlite3", sqliteDb); err != nil && os.IsNotExist(err) {
 			return err
 		} else {
+			if engine != nil {
+				engine.AddHook(sqlTraceHook{})
+			}
 			dbm.sqlite3DBMap[dbAlias] = &Sqlite3DB{
 				Engine:     engine,
 				DBPath:     sqliteDb,
@@ -221,6 +227,7 @@ func (dbm *DbManager) GetSqlite3(dbAlias string) (*xorm.Engine, error) {
 			if engine, err := xorm.NewEngine("sqlite3", sqliteDb); err != nil && os.IsNotExist(err) {
 				return nil, err
 			} else {
+				engine.AddHook(sqlTraceHook{})
 				engine.ShowSQL(db.ShowSQL)
 				setLogLevel(engine, db.LogLevel)
 				return engine, nil
@@ -235,6 +242,7 @@ func (dbm *DbManager) SetSqlite3(dbAlias string, engine *xorm.Engine) {
 	defer dbm.mutex.Unlock()
 
 	if db, has := dbm.sqlite3DBMap[dbAlias]; has {
+		engine.AddHook(sqlTraceHook{})
 		db.Engine = engine
 	}
 }
@@ -267,3 +275,92 @@ func (dbm *DbManager) RemoveSqlite3(dbAlias string) {
 
 	delete(dbm.sqlite3DBMap, dbAlias)
 }
+
+// Close close all mysql and persistent sqlite3 db engines.
+func (dbm *DbManager) Close() error {
+	dbm.mutex.Lock()
+	defer dbm.mutex.Unlock()
+
+	var lastErr error
+	for _, db := range dbm.mysqlDBMap {
+		if db.Engine != nil {
+			if err := db.Engine.Close(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	for _, db := range dbm.sqlite3DBMap {
+		if db.Persistent && db.Engine != nil {
+			if err := db.Engine.Close(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// Ping ping all mysql and sqlite3 databases, return the errors keyed by "mysql:alias" or "sqlite3:alias".
+func (dbm *DbManager) Ping(ctx context.Context) map[string]error {
+	pingers := dbm.pingers(ctx)
+	errs := make(map[string]error, len(pingers))
+	for name, ping := range pingers {
+		errs[name] = ping()
+	}
+	return errs
+}
+
+// pingers 返回各数据库的检测函数
+func (dbm *DbManager) pingers(ctx context.Context) map[string]func() error {
+	dbm.mutex.Lock()
+	defer dbm.mutex.Unlock()
+
+	pingEngine := func(engine *xorm.Engine) func() error {
+		return func() error {
+			if engine == nil {
+				return errors.New("db engine is not initialized")
+			}
+			return engine.PingContext(ctx)
+		}
+	}
+
+	pingers := make(map[string]func() error, len(dbm.mysqlDBMap)+len(dbm.sqlite3DBMap))
+	for alias, db := range dbm.mysqlDBMap {
+		pingers["mysql:"+alias] = pingEngine(db.Engine)
+	}
+	for alias, db := range dbm.sqlite3DBMap {
+		if db.Persistent {
+			pingers["sqlite3:"+alias] = pingEngine(db.Engine)
+			continue
+		}
+		// 非持久化的 sqlite3 每次打开新连接
+		alias := alias
+		pingers["sqlite3:"+alias] = func() error {
+			engine, err := dbm.GetSqlite3(alias)
+			if err != nil {
+				return err
+			}
+			defer engine.Close()
+			return engine.PingContext(ctx)
+		}
+	}
+	return pingers
+}
+
+// engines 返回 mysql 和持久化的 sqlite3 的数据库引擎，键为 mysql:alias 或 sqlite3:alias
+func (dbm *DbManager) engines() map[string]*xorm.Engine {
+	dbm.mutex.Lock()
+	defer dbm.mutex.Unlock()
+
+	engines := make(map[string]*xorm.Engine, len(dbm.mysqlDBMap)+len(dbm.sqlite3DBMap))
+	for alias, db := range dbm.mysqlDBMap {
+		if db.Engine != nil {
+			engines["mysql:"+alias] = db.Engine
+		}
+	}
+	for alias, db := range dbm.sqlite3DBMap {
+		if db.Persistent && db.Engine != nil {
+			engines["sqlite3:"+alias] = db.Engine
+		}
+	}
+	return engines
+}
@@ -102,6 +102,12 @@ func getDB(c *Context) (*xorm.Engine, error) {
 	}
 }
 
+// getTxSession return the session shared by batch calls in one transaction.
+func getTxSession(c *Context) *xorm.Session {
+	session, _ := c.Get("dbop_session").(*xorm.Session)
+	return session
+}
+
 func closeDB(c *Context, db *xorm.Engine) error {
 	dbType := c.App.Config.GetDefaultString("dbop.db_type", "mysql")
 
@@ -17,14 +17,19 @@ func Create(c *Context, bean interface{}, params *Params) interface{} {
 }
 
 func CreateEx(c *Context, bean interface{}, params *Params, querySettings map[string]string) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionCreateEx(c, session, bean, params, querySettings)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 
 	return SessionCreateEx(c, session, bean, params, querySettings)
@@ -34,7 +39,9 @@ func SessionCreate(c *Context, session *xorm.Session, bean interface{}, params *
 	return SessionCreateEx(c, session, bean, params, nil)
 }
 
-func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params *Params, querySettings map[string]string) interface{} {
+func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params *Params, querySettings map[string]string) (data interface{}) {
+	span, end := c.enterSpan("dbop.Create")
+	defer func() { end(data) }()
 	var modelName string
 	var modelVal reflect.Value
 	var modelPt interface{}
@@ -53,6 +60,7 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 	if isString {
 		t := reflect.New(modelDefine.Type)
 		modelPt = t.Interface()
@@ -215,7 +223,7 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 	enableTrans := false
 	if len(indexColumnTypes) > 0 {
 		// 如果外部已经启动事务，内部不再启动
-		if session.Tx == nil {
+		if !session.IsInTx() {
 			enableTrans = true
 		}
 	}
@@ -312,10 +320,12 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 		if enableTrans {
 			session.Rollback()
 		}
-		c.App.Logger.Error("(dbop error): [InsertFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [InsertFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "InsertFailed", modelName).SetMessage("Insert failed.")
 	}
 
+	span.SetAttribute("db.rows_affected", 1)
+
 	// 返回ID
 	pkFields := modelDefine.TagFields("pk")
 	if len(pkFields) == 0 {
@@ -340,7 +350,7 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 					if enableTrans {
 						session.Rollback()
 					}
-					c.App.Logger.Error("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
+					c.Logger().Errorf("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
 					return c.Error.New(ErrorInternalError, "UpdateShowIndexFailed").SetMessage("Update ShowIndex Failed.")
 				}
 			case "append":
@@ -356,7 +366,7 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 					if enableTrans {
 						session.Rollback()
 					}
-					c.App.Logger.Error("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
+					c.Logger().Errorf("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
 					return c.Error.New(ErrorInternalError, "UpdateShowIndexFailed").SetMessage("Update ShowIndex Failed.")
 				}
 			}
@@ -366,7 +376,7 @@ func SessionCreateEx(c *Context, session *xorm.Session, bean interface{}, params
 	if enableTrans {
 		err := session.Commit()
 		if err != nil {
-			c.App.Logger.Error("(dbop error): [SessionCommitFailed] %s", err.Error())
+			c.Logger().Errorf("(dbop error): [SessionCommitFailed] %s", err.Error())
 			return c.Error.New(ErrorInternalError, "SessionCommitFailed").SetMessage("Session Commit Failed.")
 		}
 	}
@@ -9,19 +9,26 @@ import (
 )
 
 func Delete(c *Context, bean interface{}, params *Params) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionDelete(c, session, bean, params)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 	return SessionDelete(c, session, bean, params)
 }
 
-func SessionDelete(c *Context, session *xorm.Session, bean interface{}, params *Params) interface{} {
+func SessionDelete(c *Context, session *xorm.Session, bean interface{}, params *Params) (data interface{}) {
+	span, end := c.enterSpan("dbop.Delete")
+	defer func() { end(data) }()
 	var modelName string
 	var modelVal reflect.Value
 
@@ -39,6 +46,7 @@ func SessionDelete(c *Context, session *xorm.Session, bean interface{}, params *
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 	if isString {
 		modelVal = reflect.Indirect(reflect.New(modelDefine.Type))
 	}
@@ -79,9 +87,10 @@ func SessionDelete(c *Context, session *xorm.Session, bean interface{}, params *
 		affected, err = session.ID(pk).Delete(pModel)
 	}
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DeleteFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DeleteFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DeleteFailed", modelName).SetMessage("Delete failed.")
 	}
 
+	span.SetAttribute("db.rows_affected", affected)
 	return utils.Combine("Affected", affected)
 }
@@ -19,20 +19,27 @@ func SessionDetail(c *Context, session *xorm.Session, beans interface{}, params
 }
 
 func DetailJoin(c *Context, bean interface{}, params *Params, joinConds [][]string) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionDetailJoin(c, session, bean, params, joinConds)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 
 	return SessionDetailJoin(c, session, bean, params, joinConds)
 }
 
-func SessionDetailJoin(c *Context, session *xorm.Session, bean interface{}, params *Params, joinConds [][]string) interface{} {
+func SessionDetailJoin(c *Context, session *xorm.Session, bean interface{}, params *Params, joinConds [][]string) (data interface{}) {
+	span, end := c.enterSpan("dbop.Detail")
+	defer func() { end(data) }()
 	if joinConds != nil {
 		// format: (join_operator, tablename, condition)
 		for _, joinCond := range joinConds {
@@ -77,6 +84,7 @@ func SessionDetailJoin(c *Context, session *xorm.Session, bean interface{}, para
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 	if isString {
 		modelVal = reflect.Indirect(reflect.New(modelDefine.Type))
 	}
@@ -138,12 +146,14 @@ func SessionDetailJoin(c *Context, session *xorm.Session, bean interface{}, para
 	pModel := modelVal.Addr().Interface()
 	has, err := session.Omit(omitColumns...).Where(whereClause, pk...).Get(pModel)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [GetFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [GetFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "GetFailed", modelDefine.MainModelName).SetMessage("Get failed.")
 	}
 	if !has {
+		span.SetAttribute("db.rows", 0)
 		return c.Error.New(ErrorObjectNotExist, modelDefine.MainModelName)
 	}
+	span.SetAttribute("db.rows", 1)
 
 	var item interface{}
 	if len(hiddenDetailFields) > 0 {
@@ -19,23 +19,30 @@ func SessionList(c *Context, session *xorm.Session, beans interface{}, params *P
 }
 
 func ListJoin(c *Context, beans interface{}, params *Params, querySettings map[string]string, joinConds [][]string) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionListJoin(c, session, beans, params, querySettings, joinConds)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 
 	return SessionListJoin(c, session, beans, params, querySettings, joinConds)
 }
 
-func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, params *Params, querySettings map[string]string, joinConds [][]string) interface{} {
-	countSession := session.Engine().NewSession()
+func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, params *Params, querySettings map[string]string, joinConds [][]string) (data interface{}) {
+	span, end := c.enterSpan("dbop.List")
+	defer func() { end(data) }()
+	countSession := c.NewSession(session.Engine())
 	defer countSession.Close()
-	findSession := session.Engine().NewSession()
+	findSession := c.NewSession(session.Engine())
 	defer findSession.Close()
 
 	if joinConds != nil {
@@ -81,6 +88,7 @@ func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, param
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 
 	// 查询定义处理
 	allQueryDefines := parseQuerySettings(querySettings)
@@ -297,9 +305,10 @@ func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, param
 	}
 	totalCount, err := countSession.Count(pModel)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [CountFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [CountFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "CountFailed", modelName).SetMessage("Count failed.")
 	}
+	span.SetAttribute("db.total", totalCount)
 
 	// 记录顺序控制相关变量
 	var showIndexField string
@@ -393,7 +402,7 @@ func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, param
 		if params.Has("_pageNumber") {
 			pageNumber = params.GetInt("_pageNumber")
 			if pageNumber < 1 {
-				pageNumber = pageNumber
+				pageNumber = 1
 			}
 		}
 		// if params.Has("_pageSize") {
@@ -422,11 +431,12 @@ func SessionListJoin(c *Context, session *xorm.Session, beans interface{}, param
 		// 获取结果
 		err = findSession.Where(queryString, queryArgs...).Find(beans)
 		if err != nil {
-			c.App.Logger.Error("(dbop error): [FindFailed] %s", err.Error())
+			c.Logger().Errorf("(dbop error): [FindFailed] %s", err.Error())
 			return c.Error.New(ErrorInternalError, "FindFailed", modelName).SetMessage("Find failed.")
 		}
 
 		modelVals := reflect.Indirect(reflect.ValueOf(beans))
+		span.SetAttribute("db.rows", modelVals.Len())
 
 		// 在返回结果中带上上一页最后一个showindex和下一页第一个showindex
 		if showIndexField != "" {
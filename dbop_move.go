@@ -8,20 +8,27 @@ import (
 )
 
 func Move(c *Context, bean interface{}, srcIndex, dstIndex uint32) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionMove(c, session, bean, srcIndex, dstIndex)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 
 	return SessionMove(c, session, bean, srcIndex, dstIndex)
 }
 
-func SessionMove(c *Context, session *xorm.Session, bean interface{}, srcIndex, dstIndex uint32) interface{} {
+func SessionMove(c *Context, session *xorm.Session, bean interface{}, srcIndex, dstIndex uint32) (data interface{}) {
+	span, end := c.enterSpan("dbop.Move")
+	defer func() { end(data) }()
 	if srcIndex == dstIndex {
 		return c.Error.New(ErrorInternalError, "NoObjectMoved").SetMessage("No object moved!")
 	}
@@ -44,6 +51,7 @@ func SessionMove(c *Context, session *xorm.Session, bean interface{}, srcIndex,
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 	if isString {
 		t := reflect.New(modelDefine.Type)
 		modelPt = t.Interface()
@@ -67,7 +75,7 @@ func SessionMove(c *Context, session *xorm.Session, bean interface{}, srcIndex,
 	// 检查源和目标是否存在
 	total, err := session.In(columnName, []uint32{srcIndex, dstIndex}).Count(modelPt)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [CountFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [CountFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "CountFailed").SetMessage("Count Failed.")
 	}
 	if total != 2 {
@@ -103,10 +111,14 @@ func SessionMove(c *Context, session *xorm.Session, bean interface{}, srcIndex,
 			columnName, indexFrom, columnName, indexTo,
 		)
 	}
-	if _, err := session.Exec(sql); err != nil {
-		c.App.Logger.Error("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
+	res, err := session.Exec(sql)
+	if err != nil {
+		c.Logger().Errorf("(dbop error): [UpdateShowIndexFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "UpdateShowIndexFailed").SetMessage("Update ShowIndex Failed.")
 	}
+	if affected, err := res.RowsAffected(); err == nil {
+		span.SetAttribute("db.rows_affected", affected)
+	}
 
 	return nil
 }
@@ -21,20 +21,27 @@ func SessionUpdate(c *Context, session *xorm.Session, bean interface{}, params *
 }
 
 func UpdateEx(c *Context, bean interface{}, params *Params, querySettings map[string]string) interface{} {
+	// 批量调用时共用事务
+	if session := getTxSession(c); session != nil {
+		return SessionUpdateEx(c, session, bean, params, querySettings)
+	}
+
 	db, err := getDB(c)
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [DBNotExist] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [DBNotExist] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "DBNotExist").SetMessage("Database not exist.")
 	}
 	defer closeDB(c, db)
 
-	session := db.NewSession()
+	session := c.NewSession(db)
 	defer session.Close()
 
 	return SessionUpdateEx(c, session, bean, params, querySettings)
 }
 
-func SessionUpdateEx(c *Context, session *xorm.Session, bean interface{}, params *Params, querySettings map[string]string) interface{} {
+func SessionUpdateEx(c *Context, session *xorm.Session, bean interface{}, params *Params, querySettings map[string]string) (data interface{}) {
+	span, end := c.enterSpan("dbop.Update")
+	defer func() { end(data) }()
 	var modelName string
 	var modelVal reflect.Value
 
@@ -51,6 +58,7 @@ func SessionUpdateEx(c *Context, session *xorm.Session, bean interface{}, params
 	if modelDefine == nil {
 		return c.Error.New(ErrorInternalError, "ModelNotRegistered").SetMessage("Model " + modelName + " not registered!")
 	}
+	span.SetAttribute("db.model", modelName)
 	if isString {
 		modelVal = reflect.Indirect(reflect.New(modelDefine.Type))
 	}
@@ -58,7 +66,7 @@ func SessionUpdateEx(c *Context, session *xorm.Session, bean interface{}, params
 	// 取出ID字段
 	pkFields := modelDefine.TagFields("pk")
 	if len(pkFields) == 0 {
-		c.App.Logger.Error("(dbop error): [NoPrimaryKey]")
+		c.Logger().Error("(dbop error): [NoPrimaryKey]")
 		return c.Error.New(ErrorInternalError, "NoPrimaryKey").SetMessage("No primary key.")
 	}
 
@@ -144,9 +152,10 @@ func SessionUpdateEx(c *Context, session *xorm.Session, bean interface{}, params
 	// ID作为条件
 	affected, err := session.Cols(columns...).ID(pk).Update(modelVal.Addr().Interface())
 	if err != nil {
-		c.App.Logger.Error("(dbop error): [UpdateFailed] %s", err.Error())
+		c.Logger().Errorf("(dbop error): [UpdateFailed] %s", err.Error())
 		return c.Error.New(ErrorInternalError, "UpdateFailed", modelName).SetMessage("Update failed.")
 	}
 
+	span.SetAttribute("db.rows_affected", affected)
 	return utils.Combine("Affected", affected)
 }
@@ -2,14 +2,20 @@
 
 package api
 
+import (
+	"net/http"
+)
+
 type Error struct {
 	Code    string
 	Message string
 	Data    interface{}
+
+	httpStatus int // 非0时覆盖错误定义中的HTTP状态码
 }
 
 func NewError(code string, message string) *Error {
-	return &Error{code, message, nil}
+	return &Error{code, message, nil, 0}
 }
 
 func (e *Error) Error() string {
@@ -31,6 +37,20 @@ func (e *Error) SetErrorData(err error) *Error {
 	return e
 }
 
+// SetHTTPStatus set the http status code used when api.http_status is enabled.
+func (e *Error) SetHTTPStatus(status int) *Error {
+	e.httpStatus = status
+	return e
+}
+
+// HTTPStatus return the http status code of the error, 0 means not specified.
+func (e *Error) HTTPStatus() int {
+	if e.httpStatus == 0 && e.Code == "InternalError" {
+		return http.StatusInternalServerError
+	}
+	return e.httpStatus
+}
+
 func IsError(x interface{}) bool {
 	if _, ok := x.(*Error); ok {
 		return true
@@ -2,10 +2,15 @@
 
 package api
 
+import (
+	"net/http"
+)
+
 // global error
 const (
 	errorActionNotExist = iota
 	errorSystemMaintenance
+	errorBatchRolledBack
 )
 
 var globalErrorDefines = map[ErrorType]*ErrorDefine{
@@ -20,6 +25,7 @@ var globalErrorDefines = map[ErrorType]*ErrorDefine{
 				0: "接口不存在！",
 			},
 		},
+		httpStatus: http.StatusNotFound,
 	},
 	errorSystemMaintenance: &ErrorDefine{
 		code:        "SystemMaintenance",
@@ -32,6 +38,19 @@ var globalErrorDefines = map[ErrorType]*ErrorDefine{
 				0: "系统维护中！",
 			},
 		},
+		httpStatus: http.StatusServiceUnavailable,
+	},
+	errorBatchRolledBack: &ErrorDefine{
+		code:        "RolledBack",
+		fieldCounts: []int{0},
+		msgTmpls: map[string]map[int]string{
+			"en_us": {
+				0: "Rolled back because other call in the transaction failed!",
+			},
+			"zh_cn": {
+				0: "事务中的其它调用失败，已回滚！",
+			},
+		},
 	},
 }
 
@@ -63,6 +82,7 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				1: "{1}不存在！",
 			},
 		},
+		httpStatus: http.StatusNotFound,
 	},
 	ErrorObjectDuplicated: &ErrorDefine{
 		code:        "ObjectDuplicated",
@@ -119,6 +139,7 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				1: "缺少{1:或}！",
 			},
 		},
+		httpStatus: http.StatusBadRequest,
 	},
 	ErrorInvalidParam: &ErrorDefine{
 		code:        "InvalidParam",
@@ -133,6 +154,7 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				2: "无效的{1:或}：{2}！",
 			},
 		},
+		httpStatus: http.StatusBadRequest,
 	},
 	ErrorQuotaExceed: &ErrorDefine{
 		code:        "QuotaExceed",
@@ -147,6 +169,7 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				1: "超出配额限制：{1}！",
 			},
 		},
+		httpStatus: http.StatusTooManyRequests,
 	},
 	ErrorPermissionDenied: &ErrorDefine{
 		code:        "PermissionDenied",
@@ -161,6 +184,7 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				1: "没有权限：{1}！",
 			},
 		},
+		httpStatus: http.StatusForbidden,
 	},
 	ErrorOperationFailed: &ErrorDefine{
 		code:        "OperationFailed",
@@ -187,5 +211,22 @@ var appErrorDefines = map[ErrorType]*ErrorDefine{
 				2: "服务器内部错误：{1}{2}！",
 			},
 		},
+		httpStatus: http.StatusInternalServerError,
+	},
+}
+
+// words used by api
+var apiWordMap = map[string]map[string]string{
+	"en_us": {
+		"UploadSize":    "upload size",
+		"NotFile":       "not file",
+		"WrongFileType": "wrong file type",
+		"NotBool":       "not bool",
+	},
+	"zh_cn": {
+		"UploadSize":    "上传大小",
+		"NotFile":       "不是文件",
+		"WrongFileType": "文件类型错误",
+		"NotBool":       "不是布尔值",
 	},
 }
@@ -14,26 +14,42 @@ type ErrorType uint
 
 type ErrorManager struct {
 	lang         string
+	baseLang     string                                  // 派生管理器的上级语言，作为最终回退语言
+	fallbacks    map[string][]string                     // lang-fallback langs
 	groupDefines map[string]map[ErrorType]*ErrorDefine   // group-errortype-define
 	groupWords   map[string]map[string]map[string]string // group-lang-word-phrase
-	mutex        sync.RWMutex
+	mutex        *sync.RWMutex                           // 与派生管理器共用
 }
 
 type ErrorDefine struct {
 	code        string
 	fieldCounts []int
 	msgTmpls    map[string]map[int]string
+	httpStatus  int // 开启 api.http_status 时输出的HTTP状态码，0表示200
 }
 
 // NewErrorDefine return an error define.
 func NewErrorDefine(code string, fieldCounts []int, msgTmpls map[string]map[int]string) *ErrorDefine {
-	return &ErrorDefine{code, fieldCounts, msgTmpls}
+	return &ErrorDefine{code, fieldCounts, msgTmpls, 0}
+}
+
+// SetHTTPStatus set the http status code of the error define.
+func (d *ErrorDefine) SetHTTPStatus(status int) *ErrorDefine {
+	d.httpStatus = status
+	return d
+}
+
+// HTTPStatus return the http status code of the error define.
+func (d *ErrorDefine) HTTPStatus() int {
+	return d.httpStatus
 }
 
 // NewErrorManager return an error manager.
 func NewErrorManager() *ErrorManager {
 	em := new(ErrorManager)
 	em.lang = "en_us"
+	em.mutex = new(sync.RWMutex)
+	em.fallbacks = make(map[string][]string)
 	em.groupDefines = make(map[string]map[ErrorType]*ErrorDefine, 0)
 	em.groupWords = make(map[string]map[string]map[string]string, 0)
 	return em
@@ -53,6 +69,80 @@ func (em *ErrorManager) GetLang() string {
 	return em.lang
 }
 
+// WithLang return a request-scoped error manager which shares error defines,
+// words, fallbacks and the lock with em, but generates messages in the specified language.
+// If lang is empty, the language of em is used.
+func (em *ErrorManager) WithLang(lang string) *ErrorManager {
+	baseLang := em.GetLang()
+	if lang == "" {
+		lang = baseLang
+	}
+	return &ErrorManager{
+		lang:         lang,
+		baseLang:     baseLang,
+		fallbacks:    em.fallbacks,
+		groupDefines: em.groupDefines,
+		groupWords:   em.groupWords,
+		mutex:        em.mutex,
+	}
+}
+
+// SetLangFallbacks set the fallback languages used when message of lang is not defined.
+// Fallbacks are tried in order, e.g. zh_tw -> zh_cn -> en_us.
+func (em *ErrorManager) SetLangFallbacks(lang string, fallbacks []string) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	em.fallbacks[lang] = fallbacks
+}
+
+// GetLangFallbacks return the fallback languages of lang.
+func (em *ErrorManager) GetLangFallbacks(lang string) []string {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+	return em.fallbacks[lang]
+}
+
+// HasLang check if any error define or word map is registered with lang.
+func (em *ErrorManager) HasLang(lang string) bool {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	for _, defines := range em.groupDefines {
+		for _, define := range defines {
+			if _, has := define.msgTmpls[lang]; has {
+				return true
+			}
+		}
+	}
+	for _, words := range em.groupWords {
+		if _, has := words[lang]; has {
+			return true
+		}
+	}
+	return false
+}
+
+// langChain return the languages to try when generate message.
+func (em *ErrorManager) langChain() []string {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	chain := make([]string, 0, 3)
+	var add func(lang string)
+	add = func(lang string) {
+		if lang == "" || strSliceContains(chain, lang) {
+			return
+		}
+		chain = append(chain, lang)
+		for _, fallback := range em.fallbacks[lang] {
+			add(fallback)
+		}
+	}
+	add(em.lang)
+	add(em.baseLang)
+	return chain
+}
+
 // RegisterError register a new error define in default group.
 func (em *ErrorManager) RegisterError(errType ErrorType, define *ErrorDefine) {
 	em.RegisterGroupError("default", errType, define)
@@ -70,6 +160,9 @@ func (em *ErrorManager) RegisterWords(wordMap map[string]map[string]string) {
 
 // RegisterGroupError register a new error define in specified group.
 func (em *ErrorManager) RegisterGroupError(group string, errType ErrorType, define *ErrorDefine) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
 	g, ok := em.groupDefines[group]
 	if !ok {
 		em.groupDefines[group] = make(map[ErrorType]*ErrorDefine)
@@ -80,6 +173,9 @@ func (em *ErrorManager) RegisterGroupError(group string, errType ErrorType, defi
 
 // RegisterGroupErrors register new error defines in specified group.
 func (em *ErrorManager) RegisterGroupErrors(group string, defines map[ErrorType]*ErrorDefine) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
 	g, ok := em.groupDefines[group]
 	if !ok {
 		em.groupDefines[group] = make(map[ErrorType]*ErrorDefine, 0)
@@ -90,8 +186,36 @@ func (em *ErrorManager) RegisterGroupErrors(group string, defines map[ErrorType]
 	}
 }
 
+// SetErrorHTTPStatus override the http status code of error type in default group.
+func (em *ErrorManager) SetErrorHTTPStatus(errType ErrorType, status int) {
+	em.SetGroupErrorHTTPStatus("default", errType, status)
+}
+
+// SetGroupErrorHTTPStatus override the http status code of error type in specified group.
+func (em *ErrorManager) SetGroupErrorHTTPStatus(group string, errType ErrorType, status int) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	g, ok := em.groupDefines[group]
+	if !ok {
+		return
+	}
+	define, ok := g[errType]
+	if !ok {
+		return
+	}
+
+	// 复制一份，错误定义可能被多个管理器共用
+	d := *define
+	d.httpStatus = status
+	g[errType] = &d
+}
+
 // RegisterGroupWords register new word map in specified group.
 func (em *ErrorManager) RegisterGroupWords(group string, wordMap map[string]map[string]string) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
 	g, ok := em.groupWords[group]
 	if !ok {
 		em.groupWords[group] = make(map[string]map[string]string, 0)
@@ -120,13 +244,17 @@ func (em *ErrorManager) New(errType ErrorType, fields ...string) *Error {
 
 // NewGroupError return an new error with specified error type of specified group.
 func (em *ErrorManager) NewGroupError(group string, errType ErrorType, fields ...string) *Error {
+	em.mutex.RLock()
 	groupDefines, ok := em.groupDefines[group]
+	var define *ErrorDefine
+	if ok {
+		define = groupDefines[errType]
+	}
+	em.mutex.RUnlock()
 	if !ok {
 		return NewError("InternalError", "error group not found: "+group)
 	}
-
-	define, ok := groupDefines[errType]
-	if !ok {
+	if define == nil {
 		return NewError("InternalError", "error type not found in group: "+group)
 	}
 
@@ -140,7 +268,7 @@ func (em *ErrorManager) NewGroupError(group string, errType ErrorType, fields ..
 		return NewError("InternalError", err.Error())
 	}
 
-	return NewError(code, message)
+	return NewError(code, message).SetHTTPStatus(define.httpStatus)
 }
 
 // 检查字段是否是大驼峰命名
@@ -190,20 +318,24 @@ func checkField(str string) bool {
 	return true
 }
 
-func (em *ErrorManager) transWord(group string, word string) string {
+func (em *ErrorManager) transWord(group string, word string, langs []string) string {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
 	g, ok := em.groupWords[group]
 	if !ok {
 		return word
 	}
-	words, ok := g[em.lang]
-	if !ok {
-		return word
-	}
-	phrase, ok := words[word]
-	if !ok {
-		return word
+	for _, lang := range langs {
+		words, ok := g[lang]
+		if !ok {
+			continue
+		}
+		if phrase, ok := words[word]; ok {
+			return phrase
+		}
 	}
-	return phrase
+	return word
 }
 
 func (em *ErrorManager) buildErrorCode(group string, define *ErrorDefine, fields ...string) (string, error) {
@@ -230,12 +362,17 @@ combine_flelds:
 }
 
 func (em *ErrorManager) buildErrorMessage(group string, define *ErrorDefine, fields ...string) (string, error) {
-	em.mutex.RLock()
-	defer em.mutex.RUnlock()
-
-	lang := em.lang
-	msgTmpls, ok := define.msgTmpls[lang]
-	if !ok {
+	// 按语言回退链查找消息模板
+	langs := em.langChain()
+	var msgTmpls map[int]string
+	for i, lang := range langs {
+		if tmpls, ok := define.msgTmpls[lang]; ok {
+			msgTmpls = tmpls
+			langs = langs[i:]
+			break
+		}
+	}
+	if msgTmpls == nil {
 		return "", nil
 	}
 
@@ -261,7 +398,7 @@ func (em *ErrorManager) buildErrorMessage(group string, define *ErrorDefine, fie
 		if strings.Contains(result, "|") {
 			resultWords := strings.Split(result, "|")
 			for i, resultWord := range resultWords {
-				resultWords[i] = em.transWord(group, resultWord)
+				resultWords[i] = em.transWord(group, resultWord, langs)
 			}
 			orStr := ""
 			if len(matchFields) == 2 {
@@ -271,7 +408,7 @@ func (em *ErrorManager) buildErrorMessage(group string, define *ErrorDefine, fie
 			}
 			result = strings.Join(resultWords, orStr)
 		} else {
-			result = em.transWord(group, result)
+			result = em.transWord(group, result, langs)
 		}
 
 		return result
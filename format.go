@@ -0,0 +1,116 @@
+// 输出格式
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// FormatEncoder encode the api result to bytes.
+// The result is one of *SuccessResult, *ErrorResult and *ErrorResultWithData.
+type FormatEncoder func(result interface{}, indent bool) ([]byte, error)
+
+type outputFormat struct {
+	contentType string
+	encoder     FormatEncoder
+}
+
+var formatMutex sync.RWMutex
+var formats = map[string]*outputFormat{}
+
+func init() {
+	RegisterFormat("json", "application/json; charset=utf-8", encodeJSON)
+	RegisterFormat("xml", "application/xml; charset=utf-8", encodeXML)
+	RegisterFormat("msgpack", "application/msgpack", encodeMsgpack)
+	RegisterFormat("yaml", "application/yaml; charset=utf-8", encodeYAML)
+}
+
+// RegisterFormat register an output format which can be specified by api_format param.
+// The format should also be listed in api.allow_formats config.
+func RegisterFormat(name, contentType string, encoder FormatEncoder) {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+	formats[name] = &outputFormat{contentType, encoder}
+}
+
+// getFormat return the registered output format.
+func getFormat(name string) (*outputFormat, bool) {
+	formatMutex.RLock()
+	defer formatMutex.RUnlock()
+	f, has := formats[name]
+	return f, has
+}
+
+func encodeJSON(result interface{}, indent bool) ([]byte, error) {
+	if indent {
+		return json.MarshalIndent(result, "", "    ")
+	}
+	return json.Marshal(result)
+}
+
+// resultField 结果中的字段，保持 ACTION/CODE/MESSAGE/DATA 顺序
+type resultField struct {
+	name  string
+	value interface{}
+}
+
+// resultFields 将结果转换为有序字段列表，字段值转换为通用类型
+func resultFields(result interface{}) ([]resultField, error) {
+	v := reflect.Indirect(reflect.ValueOf(result))
+	t := v.Type()
+	fields := make([]resultField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		value, err := toGeneric(v.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, resultField{t.Field(i).Name, value})
+	}
+	return fields, nil
+}
+
+// toGeneric 按JSON的编码规则将数据转换为通用类型
+// 结果只包含：nil, bool, int64, uint64, float64, string, []interface{}, map[string]interface{}
+// 与JSON输出保持一致，如遵循 json tag
+func toGeneric(data interface{}) (interface{}, error) {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(vv), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(vv), 10, 64); err == nil {
+			return u
+		}
+		f, _ := vv.Float64()
+		return f
+	case []interface{}:
+		for i, item := range vv {
+			vv[i] = convertNumbers(item)
+		}
+		return vv
+	case map[string]interface{}:
+		for k, item := range vv {
+			vv[k] = convertNumbers(item)
+		}
+		return vv
+	}
+	return v
+}
@@ -0,0 +1,184 @@
+// MessagePack输出格式
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// encodeMsgpack 编码为MessagePack，结果为包含 ACTION/CODE/MESSAGE/DATA 的 map
+// REF: https://github.com/msgpack/msgpack/blob/master/spec.md
+func encodeMsgpack(result interface{}, indent bool) ([]byte, error) {
+	fields, err := resultFields(result)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &msgpackWriter{}
+	w.writeMapHeader(len(fields))
+	for _, field := range fields {
+		w.writeString(field.name)
+		if err := w.writeValue(field.value); err != nil {
+			return nil, err
+		}
+	}
+	return w.buf.Bytes(), nil
+}
+
+type msgpackWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *msgpackWriter) writeValue(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			w.buf.WriteByte(0xc3)
+		} else {
+			w.buf.WriteByte(0xc2)
+		}
+	case int64:
+		w.writeInt(v)
+	case uint64:
+		w.writeUint(v)
+	case float64:
+		w.buf.WriteByte(0xcb)
+		w.writeUint64(math.Float64bits(v))
+	case string:
+		w.writeString(v)
+	case []interface{}:
+		w.writeArrayHeader(len(v))
+		for _, item := range v {
+			if err := w.writeValue(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// 键排序，保证输出稳定
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.writeMapHeader(len(keys))
+		for _, k := range keys {
+			w.writeString(k)
+			if err := w.writeValue(v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("msgpack: unsupported type")
+	}
+	return nil
+}
+
+func (w *msgpackWriter) writeUint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *msgpackWriter) writeUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *msgpackWriter) writeUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *msgpackWriter) writeInt(v int64) {
+	if v >= 0 {
+		w.writeUint(uint64(v))
+		return
+	}
+	switch {
+	case v >= -32:
+		w.buf.WriteByte(byte(int8(v)))
+	case v >= math.MinInt8:
+		w.buf.WriteByte(0xd0)
+		w.buf.WriteByte(byte(int8(v)))
+	case v >= math.MinInt16:
+		w.buf.WriteByte(0xd1)
+		w.writeUint16(uint16(int16(v)))
+	case v >= math.MinInt32:
+		w.buf.WriteByte(0xd2)
+		w.writeUint32(uint32(int32(v)))
+	default:
+		w.buf.WriteByte(0xd3)
+		w.writeUint64(uint64(v))
+	}
+}
+
+func (w *msgpackWriter) writeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		w.buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		w.buf.WriteByte(0xcc)
+		w.buf.WriteByte(byte(v))
+	case v <= math.MaxUint16:
+		w.buf.WriteByte(0xcd)
+		w.writeUint16(uint16(v))
+	case v <= math.MaxUint32:
+		w.buf.WriteByte(0xce)
+		w.writeUint32(uint32(v))
+	default:
+		w.buf.WriteByte(0xcf)
+		w.writeUint64(v)
+	}
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		w.buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.buf.WriteByte(0xd9)
+		w.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.buf.WriteByte(0xda)
+		w.writeUint16(uint16(n))
+	default:
+		w.buf.WriteByte(0xdb)
+		w.writeUint32(uint32(n))
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		w.buf.WriteByte(0xdc)
+		w.writeUint16(uint16(n))
+	default:
+		w.buf.WriteByte(0xdd)
+		w.writeUint32(uint32(n))
+	}
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		w.buf.WriteByte(0xde)
+		w.writeUint16(uint16(n))
+	default:
+		w.buf.WriteByte(0xdf)
+		w.writeUint32(uint32(n))
+	}
+}
@@ -0,0 +1,394 @@
+// 输出格式测试
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+type formatTestUser struct {
+	UserId   uint64            `json:"UserId"`
+	Name     string            `json:"Name"`
+	Password string            `json:"-"`
+	Score    float64           `json:"Score"`
+	Tags     []string          `json:"Tags"`
+	Extra    map[string]string `json:"Extra,omitempty"`
+	Parent   *formatTestUser   `json:"Parent"`
+}
+
+// formatTestResults 覆盖各种类型及长度的结果
+func formatTestResults() []interface{} {
+	longStr := strings.Repeat("长", 100) // 300 字节
+	return []interface{}{
+		&SuccessResult{"User.Get", "ok", &formatTestUser{
+			math.MaxUint64, "<a & b>", "secret", 1.5, []string{"x", "y"},
+			map[string]string{"1st": "one", "xmlKey": "two", "a b": "three", "名称": "four"}, nil,
+		}},
+		&SuccessResult{"Test.Numbers", "ok", map[string]interface{}{
+			"ints": []int64{0, 1, 127, 128, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1,
+				-1, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64},
+			"floats": []float64{0.5, -2.25, 1e20},
+			"bools":  []bool{true, false},
+			"nested": map[string]interface{}{"list": []interface{}{map[string]interface{}{"k": "v"}, nil}},
+		}},
+		&SuccessResult{"Test.Strings", "ok", []string{"", strings.Repeat("a", 31), strings.Repeat("b", 32), longStr,
+			strings.Repeat("c", 70000)}},
+		&SuccessResult{"Test.Big", "ok", make([]int, 20)},
+		&ErrorResult{"User.Get", "InvalidParam:UserId", "Invalid param UserId!"},
+		&ErrorResultWithData{"User.Get", "InvalidParam:UserId", "Invalid param UserId!",
+			[]*ParamError{{"UserId", "InvalidParam:UserId", "Invalid param UserId!"}}},
+	}
+}
+
+// resultGeneric 返回结果按JSON规则转换后的通用类型，字段顺序与输出一致
+func resultGeneric(t *testing.T, result interface{}) ([]string, map[string]interface{}) {
+	t.Helper()
+	fields, err := resultFields(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(fields))
+	values := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		names = append(names, field.name)
+		values[field.name] = field.value
+	}
+	return names, values
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	for _, result := range formatTestResults() {
+		names, want := resultGeneric(t, result)
+		b, err := encodeMsgpack(result, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := bytes.NewReader(b)
+		got, err := decodeMsgpack(r)
+		if err != nil {
+			t.Fatalf("decode %T: %v", result, err)
+		}
+		if r.Len() != 0 {
+			t.Errorf("%T: %d bytes left after decoding", result, r.Len())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%T: decoded = %v, want %v", result, got, want)
+		}
+		// 字段按 ACTION/CODE/MESSAGE/DATA 顺序输出
+		if keys := msgpackTopKeys(b); !reflect.DeepEqual(keys, names) {
+			t.Errorf("%T: keys = %v, want %v", result, keys, names)
+		}
+	}
+
+	if _, err := encodeMsgpack(&SuccessResult{"A", "ok", func() {}}, false); err == nil {
+		t.Error("encoding func should fail")
+	}
+}
+
+// decodeMsgpack 解码 encodeMsgpack 使用到的类型
+func decodeMsgpack(r *bytes.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	readN := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	readLen := func(size int) (int, error) {
+		b, err := readN(size)
+		if err != nil {
+			return 0, err
+		}
+		switch size {
+		case 1:
+			return int(b[0]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(b)), nil
+		}
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+
+	var n int
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		b, err := readN(int(c & 0x1f))
+		return string(b), err
+	case c&0xf0 == 0x90:
+		return decodeMsgpackArray(r, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return decodeMsgpackMap(r, int(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcb:
+		b, err := readN(8)
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := readN(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		// 与 toGeneric 一致，能表示为 int64 的无符号数解码为 int64
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return u, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		b, err := readN(size)
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		// 符号扩展
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		if n, err = readLen(1 << (c - 0xd9)); err != nil {
+			return nil, err
+		}
+		b, err := readN(n)
+		return string(b), err
+	case 0xdc, 0xdd:
+		if n, err = readLen(2 << (c - 0xdc)); err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, n)
+	case 0xde, 0xdf:
+		if n, err = readLen(2 << (c - 0xde)); err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, n)
+	}
+	return nil, fmt.Errorf("unexpected msgpack type 0x%x", c)
+}
+
+func decodeMsgpackArray(r *bytes.Reader, n int) (interface{}, error) {
+	list := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func decodeMsgpackMap(r *bytes.Reader, n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, errors.New("msgpack map key should be string")
+		}
+		if m[k], err = decodeMsgpack(r); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// msgpackTopKeys 返回顶层 map 的键，保持顺序
+func msgpackTopKeys(b []byte) []string {
+	r := bytes.NewReader(b)
+	c, _ := r.ReadByte()
+	keys := []string{}
+	for i := 0; i < int(c&0x0f); i++ {
+		key, _ := decodeMsgpack(r)
+		keys = append(keys, key.(string))
+		decodeMsgpack(r)
+	}
+	return keys
+}
+
+func TestEncodeXML(t *testing.T) {
+	for _, indent := range []bool{false, true} {
+		for _, result := range formatTestResults() {
+			names, values := resultGeneric(t, result)
+			b, err := encodeXML(result, indent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(b, []byte(xml.Header)) {
+				t.Errorf("%T: missing xml header", result)
+			}
+
+			root := &xmlTestNode{}
+			if err := xml.Unmarshal(b, root); err != nil {
+				t.Fatalf("%T: invalid xml: %v\n%s", result, err, b)
+			}
+			if root.XMLName.Local != "RESULT" || len(root.Nodes) != len(names) {
+				t.Fatalf("%T: unexpected root %s with %d children", result, root.XMLName.Local, len(root.Nodes))
+			}
+			for i, node := range root.Nodes {
+				if node.XMLName.Local != names[i] {
+					t.Errorf("%T: field %d = %s, want %s", result, i, node.XMLName.Local, names[i])
+					continue
+				}
+				want := xmlExpected(values[names[i]])
+				if got := node.generic(); !reflect.DeepEqual(got, want) {
+					t.Errorf("%T indent=%v: %s = %#v, want %#v", result, indent, names[i], got, want)
+				}
+			}
+		}
+	}
+
+	b, _ := encodeXML(&SuccessResult{"A", "ok", map[string]interface{}{"B": []int{1}}}, true)
+	want := xml.Header + "<RESULT>\n    <ACTION>A</ACTION>\n    <CODE>ok</CODE>\n    <DATA>\n        <B>\n" +
+		"            <item>1</item>\n        </B>\n    </DATA>\n</RESULT>"
+	if string(b) != want {
+		t.Errorf("indented xml = %s, want %s", b, want)
+	}
+}
+
+type xmlTestNode struct {
+	XMLName xml.Name
+	Key     string        `xml:"key,attr"`
+	Content string        `xml:",chardata"`
+	Nodes   []xmlTestNode `xml:",any"`
+}
+
+// generic 将元素转为通用类型：子元素全部为无 key 的 item 时为数组，否则为 map
+func (n *xmlTestNode) generic() interface{} {
+	if len(n.Nodes) == 0 {
+		return strings.TrimSpace(n.Content)
+	}
+	isList := true
+	for _, child := range n.Nodes {
+		if child.XMLName.Local != "item" || child.Key != "" {
+			isList = false
+		}
+	}
+	if isList {
+		list := make([]interface{}, 0, len(n.Nodes))
+		for i := range n.Nodes {
+			list = append(list, n.Nodes[i].generic())
+		}
+		return list
+	}
+	m := map[string]interface{}{}
+	for i, child := range n.Nodes {
+		key := child.XMLName.Local
+		if child.Key != "" {
+			key = child.Key
+		}
+		m[key] = n.Nodes[i].generic()
+	}
+	return m
+}
+
+// xmlExpected 返回通用类型在XML中解码后的值，标量及nil都为字符串
+func xmlExpected(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			m[k] = xmlExpected(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, 0, len(vv))
+		for _, item := range vv {
+			list = append(list, xmlExpected(item))
+		}
+		return list
+	}
+	return scalarString(v)
+}
+
+func TestEncodeYAML(t *testing.T) {
+	for _, result := range formatTestResults() {
+		names, values := resultGeneric(t, result)
+		b, err := encodeYAML(result, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ms yaml.MapSlice
+		if err := yaml.Unmarshal(b, &ms); err != nil {
+			t.Fatalf("%T: invalid yaml: %v", result, err)
+		}
+		if len(ms) != len(names) {
+			t.Fatalf("%T: %d fields, want %d", result, len(ms), len(names))
+		}
+		for i, item := range ms {
+			if item.Key != names[i] {
+				t.Errorf("%T: field %d = %v, want %s", result, i, item.Key, names[i])
+				continue
+			}
+			got := yamlGeneric(item.Value)
+			if want := yamlGeneric(values[names[i]]); !reflect.DeepEqual(got, want) {
+				t.Errorf("%T: %s = %#v, want %#v", result, names[i], got, want)
+			}
+		}
+	}
+}
+
+// yamlGeneric 统一yaml解码结果与通用类型中的数值及map类型
+func yamlGeneric(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case int:
+		return int64(vv)
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(vv))
+		for _, item := range vv {
+			m[fmt.Sprint(item.Key)] = yamlGeneric(item.Value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			m[fmt.Sprint(k)] = yamlGeneric(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			m[k] = yamlGeneric(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, 0, len(vv))
+		for _, item := range vv {
+			list = append(list, yamlGeneric(item))
+		}
+		return list
+	}
+	return v
+}
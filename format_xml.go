@@ -0,0 +1,149 @@
+// XML输出格式
+
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// encodeXML 编码为XML，格式如：
+// <RESULT><ACTION>User.List</ACTION><CODE>ok</CODE><DATA>...</DATA></RESULT>
+// 数组元素使用 <item> 标签，不能作为标签名的键使用 <item key="...">
+func encodeXML(result interface{}, indent bool) ([]byte, error) {
+	fields, err := resultFields(result)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &xmlWriter{indent: indent}
+	w.buf.WriteString(xml.Header)
+	w.buf.WriteString("<RESULT>")
+	for _, field := range fields {
+		if err := w.writeElement(field.name, "", field.value, 1); err != nil {
+			return nil, err
+		}
+	}
+	w.newline(0)
+	w.buf.WriteString("</RESULT>")
+	return w.buf.Bytes(), nil
+}
+
+type xmlWriter struct {
+	buf    bytes.Buffer
+	indent bool
+}
+
+func (w *xmlWriter) newline(depth int) {
+	if w.indent {
+		w.buf.WriteByte('\n')
+		w.buf.WriteString(strings.Repeat("    ", depth))
+	}
+}
+
+func (w *xmlWriter) escape(s string) error {
+	return xml.EscapeText(&w.buf, []byte(s))
+}
+
+func (w *xmlWriter) openTag(name, key string) error {
+	w.buf.WriteByte('<')
+	w.buf.WriteString(name)
+	if key != "" {
+		w.buf.WriteString(` key="`)
+		if err := w.escape(key); err != nil {
+			return err
+		}
+		w.buf.WriteByte('"')
+	}
+	return nil
+}
+
+func (w *xmlWriter) writeElement(name, key string, value interface{}, depth int) error {
+	if !isXMLName(name) {
+		name, key = "item", name
+	}
+
+	w.newline(depth)
+	if err := w.openTag(name, key); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteString("/>")
+		return nil
+	case map[string]interface{}:
+		w.buf.WriteByte('>')
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := w.writeElement(k, "", v[k], depth+1); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			w.newline(depth)
+		}
+	case []interface{}:
+		w.buf.WriteByte('>')
+		for _, item := range v {
+			if err := w.writeElement("item", "", item, depth+1); err != nil {
+				return err
+			}
+		}
+		if len(v) > 0 {
+			w.newline(depth)
+		}
+	default:
+		w.buf.WriteByte('>')
+		if err := w.escape(scalarString(v)); err != nil {
+			return err
+		}
+	}
+
+	w.buf.WriteString("</")
+	w.buf.WriteString(name)
+	w.buf.WriteByte('>')
+	return nil
+}
+
+// scalarString 将通用类型中的标量转换为字符串
+func scalarString(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return vv
+	case bool:
+		return strconv.FormatBool(vv)
+	case int64:
+		return strconv.FormatInt(vv, 10)
+	case uint64:
+		return strconv.FormatUint(vv, 10)
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	}
+	return ""
+}
+
+// isXMLName 检查是否可以作为XML标签名
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		if unicode.IsLetter(c) || c == '_' {
+			continue
+		}
+		if i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.') {
+			continue
+		}
+		return false
+	}
+	return true
+}
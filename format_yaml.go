@@ -0,0 +1,21 @@
+// YAML输出格式
+
+package api
+
+import (
+	"gopkg.in/yaml.v2"
+)
+
+// encodeYAML 编码为YAML，保持 ACTION/CODE/MESSAGE/DATA 的顺序
+func encodeYAML(result interface{}, indent bool) ([]byte, error) {
+	fields, err := resultFields(result)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make(yaml.MapSlice, 0, len(fields))
+	for _, field := range fields {
+		ms = append(ms, yaml.MapItem{Key: field.name, Value: field.value})
+	}
+	return yaml.Marshal(ms)
+}
@@ -17,8 +17,8 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
 	github.com/gorilla/websocket v1.4.2
-	github.com/streadway/simpleuuid v0.0.0-20130420165545-6617b501e485
 	github.com/urfave/negroni v1.0.0
+	gopkg.in/yaml.v2 v2.2.2
 	xorm.io/core v0.7.3
 	xorm.io/xorm v1.2.5
 )
@@ -0,0 +1,152 @@
+// 健康检查
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 健康检查函数，返回nil表示正常
+type HealthCheckFunc func() error
+
+type healthCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+// AddHealthCheck add a check to the readiness endpoint, the app is not ready if check return error.
+func (app *App) AddHealthCheck(name string, check func() error) {
+	app.healthChecks = append(app.healthChecks, &healthCheck{name, check})
+}
+
+// healthCheckResult 单项检查结果
+type healthCheckResult struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// healthResult 检查结果
+type healthResult struct {
+	Status string                        `json:"status"`
+	Checks map[string]*healthCheckResult `json:"checks"`
+}
+
+// addHealthRoutes 注册健康检查接口，配置项：
+//
+//	api.health.enabled: 是否启用，默认为 false
+//	api.health.live_path: 存活检查路径，进程能处理请求即返回正常，默认为 /healthz
+//	api.health.ready_path: 就绪检查路径，检查数据库、维护模式和 AddHealthCheck 添加的检查，默认为 /readyz
+//	api.health.timeout: 就绪检查的超时时间，默认为 5s
+//
+// 检查接口不经过中间件，不受 app.host 限制，方便负载均衡或容器平台调用。
+func (app *App) addHealthRoutes(apiPaths []string) {
+	cfg := app.Config
+	if !cfg.GetDefaultBool("api.health.enabled", false) {
+		return
+	}
+
+	livePath := cfg.GetDefaultString("api.health.live_path", "/healthz")
+	readyPath := cfg.GetDefaultString("api.health.ready_path", "/readyz")
+	for _, path := range []string{livePath, readyPath} {
+		if strSliceContains(apiPaths, path) {
+			app.Logger.Warningf("(api) health path %s conflicts with api path, ignored.", path)
+			return
+		}
+	}
+
+	app.Router.HandleFunc(livePath, func(w http.ResponseWriter, r *http.Request) {
+		result := &healthResult{"ok", map[string]*healthCheckResult{
+			"process": {"ok", 0, ""},
+		}}
+		writeHealthResult(w, result)
+	})
+	app.Router.HandleFunc(readyPath, func(w http.ResponseWriter, r *http.Request) {
+		timeout := getConfigDuration(cfg, "api.health.timeout", 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		writeHealthResult(w, app.checkReadiness(ctx))
+	})
+}
+
+// checkReadiness 并发执行所有就绪检查
+func (app *App) checkReadiness(ctx context.Context) *healthResult {
+	result := &healthResult{"ok", map[string]*healthCheckResult{}}
+	var mutex sync.Mutex
+	setResult := func(name string, start time.Time, err error) {
+		checkResult := &healthCheckResult{"ok", float64(time.Since(start).Microseconds()) / 1000, ""}
+		if err != nil {
+			checkResult.Status = "fail"
+			checkResult.Error = err.Error()
+		}
+		mutex.Lock()
+		result.Checks[name] = checkResult
+		if err != nil {
+			result.Status = "fail"
+		}
+		mutex.Unlock()
+	}
+
+	// 维护模式
+	var maintenanceErr error
+	if app.UnderMaintenance {
+		maintenanceErr = errors.New("system is under maintenance")
+	}
+	setResult("maintenance", time.Now(), maintenanceErr)
+
+	var wg sync.WaitGroup
+	for name, ping := range app.DB.pingers(ctx) {
+		wg.Add(1)
+		go func(name string, ping func() error) {
+			defer wg.Done()
+			start := time.Now()
+			setResult("db:"+name, start, runHealthCheck(ctx, ping))
+		}(name, ping)
+	}
+	for _, c := range app.healthChecks {
+		wg.Add(1)
+		go func(c *healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			setResult(c.name, start, runHealthCheck(ctx, c.check))
+		}(c)
+	}
+	wg.Wait()
+	return result
+}
+
+// runHealthCheck 执行检查，超时后返回错误，不等待检查函数结束
+func runHealthCheck(ctx context.Context, check HealthCheckFunc) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				done <- fmt.Errorf("check panic: %v", e)
+			}
+		}()
+		done <- check()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeHealthResult 输出检查结果，失败时返回 503
+func writeHealthResult(w http.ResponseWriter, result *healthResult) {
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if result.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
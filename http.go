@@ -1,13 +1,17 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/go-apibox/config"
 )
 
 // tcpKeepAliveListener sets TCP keep-alive timeouts on accepted
@@ -28,172 +32,176 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 	tc.SetKeepAlivePeriod(3 * time.Minute)
 	return tc, nil
 }
-func (app *App) listen(netType, laddr string) (net.Listener, error) {
-	ln, err := net.Listen(netType, laddr)
+
+func (app *App) listen(define *ListenerDefine) (net.Listener, error) {
+	var ln net.Listener
+	var err error
+	if inheritedLn := takeInheritedListener(define.Addr); inheritedLn != nil {
+		// 平滑重启时使用父进程传递的socket
+		ln = inheritedLn
+	} else if sdLn, sdErr := takeSystemdListener(define.FdName, define.Network(), define.Addr); sdLn != nil || sdErr != nil {
+		// systemd socket activation
+		ln, err = sdLn, sdErr
+		if ln != nil {
+			app.Logger.Noticef("using systemd socket %s", ln.Addr().String())
+		}
+	} else {
+		ln, err = net.Listen(define.Network(), define.Addr)
+	}
 	for _, listenEventHandler := range app.listenEventHandlers {
 		listenEventHandler(err)
 	}
+	for _, listenerEventHandler := range app.listenerEventHandlers {
+		listenerEventHandler(define, err)
+	}
 	return ln, err
 }
 
-func (app *App) signalHandler(ln net.Listener) {
+// notifyParentReady 通知父进程已开始监听，父进程收到后退出
+func notifyParentReady() {
+	envFd := os.Getenv("APP_READY_FD")
+	if envFd == "" {
+		return
+	}
+	os.Setenv("APP_READY_FD", "") // 读取完立即清空，防止被子进程继承
+	fd, err := strconv.Atoi(envFd)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+func (app *App) signalHandler() {
 	// REF: http://stackoverflow.com/questions/16681944/how-to-reliably-unlink-a-unix-domain-socket-in-go-programming-language
 	// Unix sockets must be unlinked before being reused again.
 	// Handle common process-killing signals so we can gracefully shut down:
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, os.Kill, syscall.SIGTERM)
-	go func(c chan os.Signal, ln net.Listener) {
-		app.sockClosed = make(chan bool)
-
+	go func(c chan os.Signal) {
 		// Wait for a SIGINT or SIGKILL:
 		sig := <-c
 		app.Logger.Noticef("Caught signal %s: shutting down.", sig)
-		// Stop listening (and unlink the socket if unix type):
-		ln.Close()
-
 		signal.Stop(c)
 
-		app.sockClosed <- true
-	}(sigc, ln)
+		// Stop listening (and unlink the socket if unix type), then wait for active requests:
+		app.gracefulShutdown()
+	}(sigc)
+
+	if app.Config.GetDefaultBool("app.restart.enabled", false) {
+		app.restartHandler()
+	}
 }
 
-// http server on specified handler
-func (app *App) serve(network, addr string, handler http.Handler) error {
-	app.HTTPServer = &http.Server{Addr: addr, Handler: handler}
-	ln, err := app.listen(network, app.HTTPServer.Addr)
-	if err != nil {
-		return err
+// gracefulShutdown 在 app.shutdown_timeout 时间内关闭应用
+func (app *App) gracefulShutdown() {
+	timeout := getConfigDuration(app.Config, "app.shutdown_timeout", 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := app.Shutdown(ctx); err != nil {
+		app.Logger.Warningf("shutdown: %s", err.Error())
 	}
+}
 
-	// 与unix domain socket统一处理
-	// 当项目多次调用API库时（多个APP），有些APP使用unix domain socket，有些使用 tcp，
-	// 则可能导致使用 tcp 的 APP 不退出（因为signal被其它APP处理）
-	app.signalHandler(ln)
+// 关闭处理函数，ctx 超时后应尽快返回
+type ShutdownHandler func(ctx context.Context)
 
-	if network == "tcp" {
-		ln = tcpKeepAliveListener{ln.(*net.TCPListener)}
-	}
+// OnShutdown add handler called after active requests are drained when app shutdown,
+// such as flushing queues.
+func (app *App) OnShutdown(h ShutdownHandler) {
+	app.shutdownHandlers = append(app.shutdownHandlers, h)
+}
 
-	return app.HTTPServer.Serve(ln)
+// Shutdown gracefully shutdown the app: stop listening, wait for active requests
+// until ctx is done, then call shutdown handlers and close databases.
+// Connections still active when ctx is done are closed forcibly.
+// Hijacked connections such as websocket are not waited, use OnShutdown to close them.
+func (app *App) Shutdown(ctx context.Context) error {
+	app.shutdownOnce.Do(func() {
+		// 同时关闭所有监听
+		servers := app.httpServers()
+		errs := make(chan error, len(servers))
+		for _, server := range servers {
+			go func(server *http.Server) {
+				err := server.Shutdown(ctx)
+				if err != nil {
+					// 超时仍有未完成的请求，强制关闭连接
+					server.Close()
+				}
+				errs <- err
+			}(server)
+		}
+		for range servers {
+			if err := <-errs; err != nil && app.shutdownErr == nil {
+				app.shutdownErr = err
+			}
+		}
+		app.finishShutdown(ctx)
+	})
+	return app.shutdownErr
 }
 
-// http server on tls
-func (app *App) serveTLS(network, addr string, handler http.Handler, certFile, keyFile string) error {
-	// tcp socket
-	config := &tls.Config{
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			// 必须放前面，否则会运行报错：
-			// http2: TLSConfig.CipherSuites index 8 contains an HTTP/2-approved cipher suite (0xc02f), but it comes
-			// after unapproved cipher suites. With this configuration, clients that don't support previous, approved
-			// cipher suites may be given an unapproved one and reject the connection.
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			// tls.TLS_RSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			// tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-			// tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
-		MinVersion: tls.VersionTLS10,
-		MaxVersion: tls.VersionTLS12,
-		NextProtos: []string{},
-	}
-
-	app.HTTPServer = &http.Server{Addr: addr, Handler: handler, TLSConfig: config}
-	if addr == "" {
-		addr = ":https"
+// finishShutdown 请求处理完成后调用关闭处理函数并关闭数据库
+func (app *App) finishShutdown(ctx context.Context) {
+	for _, shutdownHandler := range app.shutdownHandlers {
+		shutdownHandler(ctx)
 	}
+	app.DB.Close()
+	close(app.shutdownDone)
+}
 
-	var err error
-	config.Certificates = make([]tls.Certificate, 1)
-	config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
+// getConfigDuration 读取时长配置，支持整数（秒）或带单位的字符串，如：1m30s
+func getConfigDuration(cfg *config.Config, key string, defaultVal time.Duration) time.Duration {
+	if v, err := cfg.GetInt(key); err == nil {
+		return time.Duration(v) * time.Second
 	}
-
-	ln, err := app.listen("tcp", app.HTTPServer.Addr)
-	if err != nil {
-		return err
-	}
-
-	if network == "tcp" {
-		tcpLn := tcpKeepAliveListener{ln.(*net.TCPListener)}
-		ln = tls.NewListener(tcpLn, config)
-	}
-
-	// 与unix domain socket统一处理
-	// 当项目多次调用API库时（多个APP），有些APP使用unix domain socket，有些使用 tcp，
-	// 则可能导致使用 tcp 的 APP 不退出（因为signal被其它APP处理）
-	app.signalHandler(ln)
-
-	return app.HTTPServer.Serve(ln)
-}
-
-// http server with cert pem and key pem
-func (app *App) serveTLSWithPemBlock(network, addr string, handler http.Handler, certPemBlock, keyPemBlock []byte) error {
-	config := &tls.Config{
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			// 必须放前面，否则会运行报错：
-			// http2: TLSConfig.CipherSuites index 8 contains an HTTP/2-approved cipher suite (0xc02f), but it comes
-			// after unapproved cipher suites. With this configuration, clients that don't support previous, approved
-			// cipher suites may be given an unapproved one and reject the connection.
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			// tls.TLS_RSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			// tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-			// tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA, // ssllabs test: INSECURE
-			tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
-		MinVersion: tls.VersionTLS10,
-		MaxVersion: tls.VersionTLS12,
-		NextProtos: []string{},
-	}
-
-	app.HTTPServer = &http.Server{Addr: addr, Handler: handler, TLSConfig: config}
-	if addr == "" {
-		addr = ":https"
+	if v, err := cfg.GetString(key); err == nil {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
 	}
+	return defaultVal
+}
 
-	var err error
-	config.Certificates = make([]tls.Certificate, 1)
-	config.Certificates[0], err = tls.X509KeyPair(certPemBlock, keyPemBlock)
-	if err != nil {
-		return err
+// httpServers 返回所有监听的 http server
+func (app *App) httpServers() []*http.Server {
+	servers := make([]*http.Server, 0, len(app.listeners))
+	for _, l := range app.listeners {
+		servers = append(servers, l.server)
 	}
-
-	ln, err := app.listen(network, addr)
-	if err != nil {
-		return err
+	if len(servers) == 0 && app.HTTPServer != nil {
+		servers = append(servers, app.HTTPServer)
 	}
+	return servers
+}
 
-	if network == "tcp" {
-		tcpLn := tcpKeepAliveListener{ln.(*net.TCPListener)}
-		ln = tls.NewListener(tcpLn, config)
-	} else {
-		ln = tls.NewListener(ln, config)
+// acceptListener 返回接受连接的监听：tcp连接启用 keep-alive，按配置解析PROXY协议头
+func (app *App) acceptListener(ln net.Listener, define *ListenerDefine) net.Listener {
+	// socket 可能继承自 systemd，按实际类型处理
+	if tcpLn, ok := ln.(*net.TCPListener); ok {
+		ln = tcpKeepAliveListener{tcpLn}
+	}
+	if define.ProxyProtocol {
+		ln = &proxyProtoListener{ln, app.trustedProxies}
 	}
+	return ln
+}
+
+// http server on specified listener
+func (app *App) serve(server *http.Server, ln net.Listener, define *ListenerDefine) error {
+	return server.Serve(app.acceptListener(ln, define))
+}
 
-	// 与unix domain socket统一处理
-	// 当项目多次调用API库时（多个APP），有些APP使用unix domain socket，有些使用 tcp，
-	// 则可能导致使用 tcp 的 APP 不退出（因为signal被其它APP处理）
-	app.signalHandler(ln)
+// http server on tls
+func (app *App) serveTLS(server *http.Server, ln net.Listener, define *ListenerDefine, config *tls.Config) error {
+	server.TLSConfig = config
+	if !strSliceContains(config.NextProtos, "h2") {
+		// 禁用 HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
 
-	return app.HTTPServer.Serve(ln)
+	return server.Serve(tls.NewListener(app.acceptListener(ln, define), config))
 }
 
 // 监听事件处理函数
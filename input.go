@@ -3,13 +3,27 @@
 package api
 
 import (
-	"net"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+
+	"github.com/go-apibox/filter"
+	"github.com/gorilla/context"
 )
 
 type Input struct {
-	Request *http.Request
+	Request    *http.Request
+	raw        interface{}              // JSON请求体解析后的数据
+	files      map[string][]*UploadFile // 上传的文件
+	uploadSize int64                    // 上传内容的总大小
+	uploadErr  *filter.Error            // 解析上传内容时的错误
+}
+
+// NewInput return an input of the request.
+func NewInput(r *http.Request) *Input {
+	return &Input{Request: r}
 }
 
 // 获取请求值
@@ -31,16 +45,12 @@ func (i *Input) GetAction() string {
 }
 
 // GetRealIp returns the real ip address of request client.
+// The header named by api.real_ip_header (X-Real-IP by default) is used only when the peer is one of api.trusted_proxies.
 func (i *Input) GetRealIp() string {
-	realIp := i.Request.Header.Get("X-Real-IP")
-	if realIp != "" {
+	if realIp, ok := context.Get(i.Request, "real_ip").(string); ok {
 		return realIp
 	}
-	if i.Request.RemoteAddr == "@" {
-		return "@"
-	}
-	remoteIp, _, _ := net.SplitHostPort(i.Request.RemoteAddr)
-	return remoteIp
+	return remoteIp(i.Request.RemoteAddr)
 }
 
 // GetAll return all request params.
@@ -64,3 +74,99 @@ func (i *Input) Has(key string) bool {
 	}
 	return false
 }
+
+// GetRaw return the decoded document of JSON request body.
+// Numbers are decoded as json.Number. If request body is not JSON, nil is returned.
+func (i *Input) GetRaw() interface{} {
+	return i.raw
+}
+
+// GetRawObject return the decoded JSON request body if it is an object.
+func (i *Input) GetRawObject() map[string]interface{} {
+	obj, _ := i.raw.(map[string]interface{})
+	return obj
+}
+
+// parseJSONBody 解析JSON请求体
+// 顶层对象中的标量值及标量数组会合并到 Request.Form 中，与表单请求保持一致
+func (i *Input) parseJSONBody(maxSize int64) error {
+	r := i.Request
+	if r.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxSize))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	// 还原请求体，以便 action 中仍可读取
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	i.raw = raw
+
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if r.PostForm == nil {
+		r.PostForm = make(url.Values)
+	}
+	for key, val := range obj {
+		strVals, ok := jsonFormValues(val)
+		if !ok {
+			continue
+		}
+		r.PostForm[key] = append(r.PostForm[key], strVals...)
+		// 请求体中的值优先于URL中的值
+		r.Form[key] = append(strVals, r.Form[key]...)
+	}
+	return nil
+}
+
+// jsonFormValues 将JSON值转换为表单值，对象及嵌套数组无法转换
+func jsonFormValues(val interface{}) ([]string, bool) {
+	switch v := val.(type) {
+	case []interface{}:
+		strVals := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := jsonScalarString(item)
+			if !ok {
+				return nil, false
+			}
+			strVals = append(strVals, str)
+		}
+		return strVals, true
+	default:
+		str, ok := jsonScalarString(v)
+		if !ok {
+			return nil, false
+		}
+		return []string{str}, true
+	}
+}
+
+// jsonScalarString 将JSON标量转换为字符串
+func jsonScalarString(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	}
+	return "", false
+}
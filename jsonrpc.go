@@ -0,0 +1,227 @@
+// JSON-RPC 2.0 接口
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// JSON-RPC 2.0 预定义错误码
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+	jsonrpcServerError    = -32000
+)
+
+// jsonrpcResponse JSON-RPC 2.0 响应
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// MarshalJSON 成功时只输出 result，失败时只输出 error
+func (resp *jsonrpcResponse) MarshalJSON() ([]byte, error) {
+	if resp.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *jsonrpcError   `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{resp.JSONRPC, resp.Error, resp.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{resp.JSONRPC, resp.Result, resp.ID})
+}
+
+// jsonrpcError JSON-RPC 2.0 错误对象，data 中保留原始错误码
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// jsonrpcErrorData 错误对象的 data 字段，与普通接口输出的 CODE/DATA 一致
+type jsonrpcErrorData struct {
+	Code string      `json:"CODE"`
+	Data interface{} `json:"DATA,omitempty"`
+}
+
+var jsonrpcNullID = json.RawMessage("null")
+
+// newJSONRPCHandler return a handler func serving routes over JSON-RPC 2.0.
+// The method of request is the action code, and params must be an object.
+func newJSONRPCHandler(app *App, routes []*Route) func(w http.ResponseWriter, r *http.Request) {
+	// 转化为MAP，提高性能
+	actionMap := buildActionMap(routes)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只支持POST
+		if r.Method != "POST" {
+			http.Error(w, "Unsupported request method!", http.StatusMethodNotAllowed)
+			return
+		}
+		span := app.tracer.startRequestSpan(r, "jsonrpc")
+		defer span.End()
+
+		maxSize := getConfigBytes(app.Config, "api.jsonrpc.max_size", 10<<20)
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+		if err != nil {
+			writeJSONRPC(w, newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcParseError, "Parse error", nil))
+			return
+		}
+
+		var raw interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			writeJSONRPC(w, newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcParseError, "Parse error", nil))
+			return
+		}
+
+		apiLang := r.URL.Query().Get("api_lang")
+
+		// 批量请求
+		if list, ok := raw.([]interface{}); ok {
+			if len(list) == 0 {
+				writeJSONRPC(w, newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcInvalidRequest, "Invalid Request", nil))
+				return
+			}
+			maxCalls := app.Config.GetDefaultInt("api.jsonrpc.max_calls", 50)
+			if len(list) > maxCalls {
+				writeJSONRPC(w, newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcInvalidRequest, "Too many requests in batch", nil))
+				return
+			}
+
+			responses := make([]*jsonrpcResponse, 0, len(list))
+			for _, item := range list {
+				if resp := serveJSONRPCCall(app, r, actionMap, item, apiLang); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			// 全部为通知时不返回内容
+			if len(responses) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeJSONRPC(w, responses)
+			return
+		}
+
+		resp := serveJSONRPCCall(app, r, actionMap, raw, apiLang)
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSONRPC(w, resp)
+	}
+}
+
+// serveJSONRPCCall 处理单个JSON-RPC请求，请求为通知时返回nil
+func serveJSONRPCCall(app *App, r *http.Request, actionMap map[string]*Route, raw interface{}, apiLang string) *jsonrpcResponse {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcInvalidRequest, "Invalid Request", nil)
+	}
+
+	// 没有 id 的请求为通知
+	idVal, hasID := obj["id"]
+	id := jsonrpcNullID
+	if hasID {
+		switch idVal.(type) {
+		case nil, string, json.Number:
+			id, _ = json.Marshal(idVal)
+		default:
+			return newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcInvalidRequest, "Invalid Request", nil)
+		}
+	}
+
+	version, _ := obj["jsonrpc"].(string)
+	method, _ := obj["method"].(string)
+	if version != "2.0" || method == "" {
+		return newJSONRPCErrorResponse(id, jsonrpcInvalidRequest, "Invalid Request", nil)
+	}
+
+	// 只支持按名称传参
+	params := map[string]interface{}{}
+	if obj["params"] != nil {
+		params, ok = obj["params"].(map[string]interface{})
+		if !ok {
+			return jsonrpcFilter(hasID, newJSONRPCErrorResponse(id, jsonrpcInvalidParams, "Invalid params", nil))
+		}
+	}
+
+	var data interface{}
+	if app.UnderMaintenance {
+		// 与调用接口时一样使用请求的语言
+		lang := apiLang
+		if s, ok := params["api_lang"].(string); ok && s != "" {
+			lang = s
+		}
+		data = app.requestErrorManager(r, lang).NewGroupError("global", errorSystemMaintenance)
+	} else if route, ok := actionMap[method]; !ok {
+		return jsonrpcFilter(hasID, newJSONRPCErrorResponse(id, jsonrpcMethodNotFound, "Method not found", nil))
+	} else {
+		data = callAction(app, r, route, params, apiLang, nil)
+	}
+
+	if !hasID {
+		return nil
+	}
+	switch v := data.(type) {
+	case *Error:
+		return newJSONRPCErrorResponse(id, jsonrpcErrorCode(v.Code), v.Message, &jsonrpcErrorData{v.Code, v.Data})
+	case Error:
+		return newJSONRPCErrorResponse(id, jsonrpcErrorCode(v.Code), v.Message, &jsonrpcErrorData{v.Code, v.Data})
+	}
+	return &jsonrpcResponse{"2.0", data, nil, id}
+}
+
+// jsonrpcFilter 通知不返回响应
+func jsonrpcFilter(hasID bool, resp *jsonrpcResponse) *jsonrpcResponse {
+	if !hasID {
+		return nil
+	}
+	return resp
+}
+
+// jsonrpcErrorCode 将错误码映射为JSON-RPC错误码，如：InvalidParam:UserId => -32602
+func jsonrpcErrorCode(code string) int {
+	if pos := strings.IndexByte(code, ':'); pos != -1 {
+		code = code[:pos]
+	}
+	switch code {
+	case "ActionNotExist":
+		return jsonrpcMethodNotFound
+	case "MissingParam", "InvalidParam":
+		return jsonrpcInvalidParams
+	case "InternalError":
+		return jsonrpcInternalError
+	}
+	return jsonrpcServerError
+}
+
+// newJSONRPCErrorResponse 生成错误响应
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string, data interface{}) *jsonrpcResponse {
+	return &jsonrpcResponse{"2.0", nil, &jsonrpcError{code, message, data}, id}
+}
+
+// writeJSONRPC 输出JSON-RPC响应
+func writeJSONRPC(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(newJSONRPCErrorResponse(jsonrpcNullID, jsonrpcInternalError, "Internal error", nil))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
@@ -0,0 +1,126 @@
+// JSON-RPC 2.0 接口测试
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newJSONRPCTestHandler(t *testing.T) (*App, http.HandlerFunc) {
+	t.Helper()
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := []*Route{
+		NewRoute("Test.Echo", func(c *Context) interface{} {
+			return map[string]interface{}{"Value": c.Input.Get("Value")}
+		}),
+		NewRoute("Test.Fail", func(c *Context) interface{} {
+			return c.Error.New(ErrorMissingParam, "Value")
+		}),
+	}
+	return app, newJSONRPCHandler(app, routes)
+}
+
+func serveJSONRPCTest(handler http.HandlerFunc, method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	handler(w, r)
+	return w
+}
+
+func TestJSONRPC(t *testing.T) {
+	_, handler := newJSONRPCTestHandler(t)
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"call", `{"jsonrpc":"2.0","method":"Test.Echo","params":{"Value":"a"},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"Value":"a"},"id":1}`},
+		{"string id", `{"jsonrpc":"2.0","method":"Test.Echo","id":"x"}`,
+			`{"jsonrpc":"2.0","result":{"Value":""},"id":"x"}`},
+		{"action error", `{"jsonrpc":"2.0","method":"Test.Fail","id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Missing param Value!","data":{"CODE":"MissingParam:Value"}},"id":1}`},
+		{"method not found", `{"jsonrpc":"2.0","method":"Test.None","id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+		{"positional params", `{"jsonrpc":"2.0","method":"Test.Echo","params":["a"],"id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params"},"id":1}`},
+		{"wrong version", `{"jsonrpc":"1.0","method":"Test.Echo","id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":1}`},
+		{"object id", `{"jsonrpc":"2.0","method":"Test.Echo","id":{}}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"parse error", `{"jsonrpc":"2.0",`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"batch", `[` +
+			`{"jsonrpc":"2.0","method":"Test.Echo","params":{"Value":"a"},"id":1},` +
+			`{"jsonrpc":"2.0","method":"Test.Echo","params":{"Value":"b"}},` +
+			`1,` +
+			`{"jsonrpc":"2.0","method":"Test.Fail","id":2}]`,
+			`[{"jsonrpc":"2.0","result":{"Value":"a"},"id":1},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},` +
+				`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Missing param Value!","data":{"CODE":"MissingParam:Value"}},"id":2}]`},
+	}
+	for _, c := range cases {
+		w := serveJSONRPCTest(handler, "POST", "/", c.body)
+		if got := w.Body.String(); got != c.want {
+			t.Errorf("%s: response = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestJSONRPCNotification(t *testing.T) {
+	_, handler := newJSONRPCTestHandler(t)
+	// 通知即使出错也不返回响应
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"Test.Echo"}`,
+		`{"jsonrpc":"2.0","method":"Test.None"}`,
+		`{"jsonrpc":"2.0","method":"Test.Echo","params":[1]}`,
+		`[{"jsonrpc":"2.0","method":"Test.Echo"},{"jsonrpc":"2.0","method":"Test.Fail"}]`,
+	} {
+		w := serveJSONRPCTest(handler, "POST", "/", body)
+		if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("%s: status = %d, body = %s, want no content", body, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serveJSONRPCTest(handler, "GET", "/", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestJSONRPCMaintenance(t *testing.T) {
+	app, handler := newJSONRPCTestHandler(t)
+	app.UnderMaintenance = true
+
+	cases := []struct {
+		url  string
+		body string
+		want string
+	}{
+		{"/", `{"jsonrpc":"2.0","method":"Test.Echo","id":1}`, "System is under maintenance!"},
+		{"/?api_lang=zh-CN", `{"jsonrpc":"2.0","method":"Test.Echo","id":1}`, "系统维护中！"},
+		{"/", `{"jsonrpc":"2.0","method":"Test.Echo","params":{"api_lang":"zh_cn"},"id":1}`, "系统维护中！"},
+	}
+	for _, c := range cases {
+		w := serveJSONRPCTest(handler, "POST", c.url, c.body)
+		var resp struct {
+			Error *jsonrpcError
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == nil {
+			t.Errorf("%s %s: response = %s", c.url, c.body, w.Body.String())
+			continue
+		}
+		if resp.Error.Message != c.want {
+			t.Errorf("%s %s: message = %s, want %s", c.url, c.body, resp.Error.Message, c.want)
+		}
+	}
+}
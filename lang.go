@@ -0,0 +1,104 @@
+// 语言协商
+
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type acceptLang struct {
+	lang    string
+	quality float64
+}
+
+// normalizeLang 转换为框架使用的语言代码格式，如：zh-TW => zh_tw
+func normalizeLang(lang string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(lang)), "-", "_", -1)
+}
+
+// parseAcceptLanguage 解析 Accept-Language 头部，按权重从高到低返回语言代码
+// 如：zh-TW,zh;q=0.9,en-US;q=0.8 => [zh_tw zh en_us]
+func parseAcceptLanguage(header string) []string {
+	if header == "" {
+		return []string{}
+	}
+
+	items := make([]acceptLang, 0, 4)
+	for _, field := range strings.Split(header, ",") {
+		parts := strings.Split(field, ";")
+		lang := normalizeLang(parts[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		items = append(items, acceptLang{lang, quality})
+	}
+
+	// 权重相同时保持原有顺序
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].quality > items[j].quality
+	})
+
+	langs := make([]string, 0, len(items))
+	for _, item := range items {
+		langs = append(langs, item.lang)
+	}
+	return langs
+}
+
+// NegotiateLang return the best language matched the Accept-Language header.
+// A language is matched if it is registered or has fallbacks configured.
+// Empty string is returned if no language matched.
+func (em *ErrorManager) NegotiateLang(acceptLanguage string) string {
+	langs := parseAcceptLanguage(acceptLanguage)
+	for _, lang := range langs {
+		if em.HasLang(lang) || len(em.GetLangFallbacks(lang)) > 0 {
+			return lang
+		}
+
+		// 仅有主语言标签时，如 zh，匹配 zh_cn
+		if strings.IndexByte(lang, '_') == -1 {
+			if matched := em.matchPrimaryLang(lang); matched != "" {
+				return matched
+			}
+		}
+	}
+	return ""
+}
+
+// matchPrimaryLang 查找与主语言标签匹配的已注册语言
+func (em *ErrorManager) matchPrimaryLang(primary string) string {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	prefix := primary + "_"
+	matched := []string{}
+	for _, defines := range em.groupDefines {
+		for _, define := range defines {
+			for lang := range define.msgTmpls {
+				if strings.HasPrefix(lang, prefix) && !strSliceContains(matched, lang) {
+					matched = append(matched, lang)
+				}
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return ""
+	}
+	// map 遍历无序，排序保证结果稳定
+	sort.Strings(matched)
+	return matched[0]
+}
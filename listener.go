@@ -0,0 +1,201 @@
+// 监听定义
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// ListenerDefine 监听定义，配置格式如：
+//
+//	app.listeners:
+//	  - addr: run/app.sock
+//	  - addr: :443
+//	    tls: true
+//	    host: api.example.com
+//	  - addr: :8443
+//	    tls: true
+//	    proxy_protocol: true
+type ListenerDefine struct {
+	Addr          string // tcp地址或unix socket路径
+	TLS           bool   // 是否启用TLS
+	Host          string // 只处理指定Host的请求，同时用于自动生成证书
+	FdName        string // 使用 systemd 传递的指定名称的socket，为空时按 Addr 匹配
+	ProxyProtocol bool   // 是否解析受信任代理发送的PROXY协议头
+}
+
+// Network return the network type of listener: tcp or unix.
+func (d *ListenerDefine) Network() string {
+	if strings.IndexByte(d.Addr, ':') == -1 {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// appListener 运行中的监听
+type appListener struct {
+	define *ListenerDefine
+	ln     net.Listener // 原始监听socket，平滑重启时传给新进程
+	server *http.Server
+}
+
+// listenerHandler 返回监听的 handler，指定了 Host 时接口路径只处理该 Host 的请求，
+// 其它路径（如健康检查）不受限制
+func (app *App) listenerHandler(define *ListenerDefine, apiPaths []string) http.Handler {
+	if define.Host == "" {
+		return app.Router
+	}
+	hostRouter := mux.NewRouter()
+	for _, path := range apiPaths {
+		hostRouter.Host(define.Host).Path(path).Handler(app.Router)
+		hostRouter.Path(path).Handler(http.NotFoundHandler())
+	}
+	hostRouter.NotFoundHandler = app.Router
+	return hostRouter
+}
+
+// 监听事件处理函数（区分监听）
+type ListenerEventHandler func(define *ListenerDefine, err error)
+
+// AddListenerEventHandler add handler when each listener listen return.
+func (app *App) AddListenerEventHandler(h ListenerEventHandler) {
+	app.listenerEventHandlers = append(app.listenerEventHandlers, h)
+}
+
+// ListenerDefines return the listener defines of app.
+// If app.listeners is not configured, app.Addr, app.tls.enabled and app.proxy_protocol will be used.
+func (app *App) ListenerDefines() ([]*ListenerDefine, error) {
+	cfg := app.Config
+	v, err := cfg.Get("app.listeners")
+	if err != nil || v == nil {
+		return []*ListenerDefine{
+			{
+				app.Addr, cfg.GetDefaultBool("app.tls.enabled", false), app.Host,
+				cfg.GetDefaultString("app.systemd.fd_name", ""), cfg.GetDefaultBool("app.proxy_protocol", false),
+			},
+		}, nil
+	}
+
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("app.listeners should be a list")
+	}
+	defines := make([]*ListenerDefine, 0, len(items))
+	for i, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("app.listeners[%d] should be a map", i)
+		}
+		define := &ListenerDefine{"", false, app.Host, "", false}
+		for key, val := range m {
+			switch key {
+			case "addr":
+				define.Addr = fmt.Sprint(val)
+			case "tls":
+				define.TLS, _ = val.(bool)
+			case "host":
+				define.Host = fmt.Sprint(val)
+			case "fd_name":
+				define.FdName = fmt.Sprint(val)
+			case "proxy_protocol":
+				define.ProxyProtocol, _ = val.(bool)
+			}
+		}
+		if define.Addr == "" {
+			return nil, fmt.Errorf("app.listeners[%d].addr is missing", i)
+		}
+		define.Addr = normalizeAddr(strings.TrimPrefix(define.Addr, "*"))
+		defines = append(defines, define)
+	}
+	return defines, nil
+}
+
+// normalizeAddr 处理unix socket路径：删除旧的socket文件，转为绝对路径并创建所在目录
+func normalizeAddr(addr string) string {
+	if strings.IndexByte(addr, ':') != -1 {
+		return addr
+	}
+
+	// remove unix domain socket file
+	// 平滑重启或 systemd socket activation 时socket由外部传递，不能删除
+	if fi, err := os.Stat(addr); err == nil && !hasInheritedListener() {
+		if fi.Mode()&os.ModeType == os.ModeSocket {
+			os.Remove(addr)
+		}
+	}
+
+	if !filepath.IsAbs(addr) {
+		progDir := filepath.Dir(os.Args[0])
+		addr = filepath.Join(progDir, addr)
+	}
+	// auto create addr parent directory if not exist
+	addrDir := filepath.Dir(addr)
+	if _, err := os.Stat(addrDir); err != nil && os.IsNotExist(err) {
+		os.MkdirAll(addrDir, 0755)
+	}
+	return addr
+}
+
+var (
+	inheritedOnce      sync.Once
+	inheritedMutex     sync.Mutex
+	inheritedListeners map[string]net.Listener
+)
+
+// loadInheritedListeners 读取平滑重启时父进程传递的socket，只在进程中读取一次
+// 格式如：APP_LISTEN_FDS="%3A80=3&%2Fpath%2Fto%2Fapp.sock=4"
+func loadInheritedListeners() {
+	inheritedOnce.Do(func() {
+		envFds := os.Getenv("APP_LISTEN_FDS")
+		if envFds == "" {
+			return
+		}
+		os.Setenv("APP_LISTEN_FDS", "") // 读取完立即清空，防止被子进程继承
+
+		fds, err := url.ParseQuery(envFds)
+		if err != nil {
+			return
+		}
+		inheritedListeners = make(map[string]net.Listener, len(fds))
+		for addr := range fds {
+			fd, err := strconv.Atoi(fds.Get(addr))
+			if err != nil {
+				continue
+			}
+			f := os.NewFile(uintptr(fd), addr)
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			if unixLn, ok := ln.(*net.UnixListener); ok {
+				unixLn.SetUnlinkOnClose(true)
+			}
+			inheritedListeners[addr] = ln
+		}
+	})
+}
+
+// takeInheritedListener 取出父进程传递的指定地址的socket，没有则返回nil
+func takeInheritedListener(addr string) net.Listener {
+	loadInheritedListeners()
+
+	inheritedMutex.Lock()
+	defer inheritedMutex.Unlock()
+	ln, ok := inheritedListeners[addr]
+	if !ok {
+		return nil
+	}
+	delete(inheritedListeners, addr)
+	return ln
+}
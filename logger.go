@@ -3,11 +3,18 @@
 package api
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-apibox/logging"
 	"github.com/go-apibox/utils"
+	"github.com/gorilla/context"
 	"github.com/urfave/negroni"
 )
 
@@ -16,32 +23,216 @@ type Logger struct {
 	// Logger inherits from log.Logger used to log messages with the Logger middleware
 	*logging.Logger
 	*utils.Matcher
+
+	format   string    // 访问日志格式：text 或 json
+	out      io.Writer // json 格式访问日志的输出
+	outMutex sync.Mutex
+}
+
+// accessLog json 格式的访问日志，每个请求一行
+type accessLog struct {
+	Time       string  `json:"time"`
+	RequestId  string  `json:"request_id,omitempty"`
+	RemoteAddr string  `json:"remote_addr"`
+	RealIp     string  `json:"real_ip"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Action     string  `json:"action"`
+	Code       string  `json:"code,omitempty"`
+	Status     int     `json:"status"`
+	Latency    float64 `json:"latency_ms"`
+	Bytes      int     `json:"bytes"`
+	UserAgent  string  `json:"user_agent"`
 }
 
 // NewLogger returns a new Logger instance
 func NewLogger(appName string) *Logger {
-	return &Logger{logging.NewLogger(appName), utils.NewMatcher()}
+	return &Logger{logging.NewLogger(appName), utils.NewMatcher(), "text", nil, sync.Mutex{}}
 }
 
-func (l *Logger) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	// 仅过滤GET请求中的action
-	action := r.URL.Query().Get("api_action")
-	if !l.Matcher.Match(action) {
-		next(rw, r)
-		return
+// SetFormat set the format of access log: text or json.
+// In json format, one json object per request is written to the access log output.
+func (l *Logger) SetFormat(format string) {
+	if format != "json" {
+		format = "text"
 	}
+	l.format = format
+}
+
+// SetOutput set the output of json format access log, default is the ACCESS_LOG file or stdout.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.outMutex.Lock()
+	l.out = w
+	l.outMutex.Unlock()
+}
 
+func (l *Logger) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	start := time.Now()
 
-	var ipPrefix string
+	// URL中指定了action时在处理前输出开始日志，POST的action需在请求解析后才能确定
+	queryAction := r.URL.Query().Get("api_action")
+	var logPrefix string
+	if t := getRequestTrace(r); t != nil {
+		logPrefix = "[" + t.requestId + "] "
+	}
 	if r.RemoteAddr != "@" {
-		ipPrefix = r.RemoteAddr + " - "
+		logPrefix += r.RemoteAddr + " - "
+	}
+	startLogged := false
+	if l.format == "text" && queryAction != "" && l.Matcher.Match(queryAction) {
+		l.Infof("%vStarted %s %s", logPrefix, r.Method, r.RequestURI)
+		startLogged = true
 	}
-
-	l.Infof("%vStarted %s %s", ipPrefix, r.Method, r.RequestURI)
 
 	next(rw, r)
 
+	// 请求处理后 Form 中已包含请求体中的参数
+	action := queryAction
+	if r.Form != nil {
+		action = r.Form.Get("api_action")
+	}
+	if !l.Matcher.Match(action) {
+		return
+	}
+
 	res := rw.(negroni.ResponseWriter)
-	l.Infof("%vCompleted %v %s in %v", ipPrefix, res.Status(), http.StatusText(res.Status()), time.Since(start))
+	status := res.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if l.format == "text" {
+		if startLogged || action == "" {
+			l.Infof("%vCompleted %v %s in %v", logPrefix, status, http.StatusText(status), time.Since(start))
+		} else {
+			l.Infof("%vCompleted %s %s %s %v %s in %v", logPrefix, r.Method, r.RequestURI, action, status, http.StatusText(status), time.Since(start))
+		}
+		return
+	}
+
+	var code string
+	if data, ok := context.GetOk(r, "returnData"); ok {
+		code = "ok"
+		switch v := data.(type) {
+		case *Error:
+			code = v.Code
+		case Error:
+			code = v.Code
+		}
+	}
+	l.writeAccessLog(&accessLog{
+		start.Format(time.RFC3339Nano),
+		rw.Header().Get("X-Request-Id"),
+		r.RemoteAddr,
+		NewInput(r).GetRealIp(),
+		r.Method,
+		r.RequestURI,
+		action,
+		code,
+		status,
+		float64(time.Since(start).Microseconds()) / 1000,
+		res.Size(),
+		r.UserAgent(),
+	})
+}
+
+// writeAccessLog 输出一行 json 格式的访问日志
+func (l *Logger) writeAccessLog(log *accessLog) {
+	b, err := json.Marshal(log)
+	if err != nil {
+		l.Warningf("(api) marshal access log failed: %s", err.Error())
+		return
+	}
+	b = append(b, '\n')
+
+	l.outMutex.Lock()
+	defer l.outMutex.Unlock()
+	if l.out == nil {
+		l.out = defaultAccessLogOutput()
+	}
+	l.out.Write(b)
+}
+
+// defaultAccessLogOutput 与 go-apibox/logging 一致，输出到 ACCESS_LOG 指定的文件，未指定时输出到 stdout
+func defaultAccessLogOutput() io.Writer {
+	accessLogFile := os.Getenv("ACCESS_LOG")
+	if accessLogFile == "" {
+		return os.Stdout
+	}
+	f, err := os.OpenFile(accessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return io.Discard
+	}
+	return f
+}
+
+// logPanic 输出请求处理中的 panic 及调用栈，带上请求ID，用于 negroni.Recovery
+func (l *Logger) logPanic(info *negroni.PanicInformation) {
+	var requestId string
+	if t := getRequestTrace(info.Request); t != nil {
+		requestId = t.requestId
+	}
+	newRequestLogger(l, requestId).Errorf("PANIC: %v\n%s", info.RecoveredPanic, debug.Stack())
+}
+
+// RequestLogger is a logger prefixing each line with the request id, see Context.Logger.
+// Only the lines logged through RequestLogger are prefixed, App.Logger is shared by
+// all requests and its lines are not prefixed.
+type RequestLogger struct {
+	logger *logging.Logger
+	prefix string
+}
+
+// newRequestLogger 返回写入 l 的日志，调用位置按 RequestLogger 的调用方计算
+func newRequestLogger(l *Logger, requestId string) *RequestLogger {
+	logger := *l.Logger.Logger
+	logger.ExtraCalldepth++
+	var prefix string
+	if requestId != "" {
+		prefix = "[" + requestId + "] "
+	}
+	return &RequestLogger{&logging.Logger{Logger: &logger}, prefix}
+}
+
+// args 参数之间会以空格分隔，前缀不需要带空格
+func (l *RequestLogger) args(args []interface{}) []interface{} {
+	if l.prefix == "" {
+		return args
+	}
+	return append([]interface{}{strings.TrimSuffix(l.prefix, " ")}, args...)
+}
+
+func (l *RequestLogger) Debug(args ...interface{}) { l.logger.Debug(l.args(args)...) }
+
+func (l *RequestLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf(l.prefix+format, args...)
+}
+
+func (l *RequestLogger) Info(args ...interface{}) { l.logger.Info(l.args(args)...) }
+
+func (l *RequestLogger) Infof(format string, args ...interface{}) {
+	l.logger.Infof(l.prefix+format, args...)
+}
+
+func (l *RequestLogger) Notice(args ...interface{}) { l.logger.Notice(l.args(args)...) }
+
+func (l *RequestLogger) Noticef(format string, args ...interface{}) {
+	l.logger.Noticef(l.prefix+format, args...)
+}
+
+func (l *RequestLogger) Warning(args ...interface{}) { l.logger.Warning(l.args(args)...) }
+
+func (l *RequestLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warningf(l.prefix+format, args...)
+}
+
+func (l *RequestLogger) Error(args ...interface{}) { l.logger.Error(l.args(args)...) }
+
+func (l *RequestLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(l.prefix+format, args...)
+}
+
+func (l *RequestLogger) Critical(args ...interface{}) { l.logger.Critical(l.args(args)...) }
+
+func (l *RequestLogger) Criticalf(format string, args ...interface{}) {
+	l.logger.Criticalf(l.prefix+format, args...)
 }
@@ -0,0 +1,260 @@
+// 监控指标
+
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的请求耗时分布区间（秒）
+var defaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsUnknownAction 不存在的接口统一记录为此名称，防止指标数量无限增长
+const metricsUnknownAction = "_unknown"
+
+// actionMetrics 单个接口的指标
+type actionMetrics struct {
+	count   uint64
+	errors  map[string]uint64 // 按错误码统计
+	buckets []uint64          // 与 metrics.buckets 对应，不累加
+	sum     float64
+}
+
+// metrics 记录接口请求数、错误数和耗时分布，以 Prometheus 文本格式输出
+type metrics struct {
+	namespace string
+	buckets   []float64
+
+	mutex   sync.Mutex
+	actions map[string]*actionMetrics
+}
+
+func newMetrics(namespace string, buckets []float64) *metrics {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &metrics{
+		namespace: namespace,
+		buckets:   buckets,
+		actions:   map[string]*actionMetrics{},
+	}
+}
+
+// observe 记录一次请求，m 为nil时不记录
+func (m *metrics) observe(action string, resData interface{}, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	var code string
+	switch v := resData.(type) {
+	case *Error:
+		code = v.Code
+	case Error:
+		code = v.Code
+	}
+	// 去掉错误码中的字段名，如：InvalidParam:UserId => InvalidParam
+	if pos := strings.IndexByte(code, ':'); pos != -1 {
+		code = code[:pos]
+	}
+	seconds := duration.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	am, ok := m.actions[action]
+	if !ok {
+		am = &actionMetrics{0, map[string]uint64{}, make([]uint64, len(m.buckets)), 0}
+		m.actions[action] = am
+	}
+	am.count++
+	am.sum += seconds
+	if code != "" {
+		am.errors[code]++
+	}
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			am.buckets[i]++
+			break
+		}
+	}
+}
+
+// writeActions 输出接口指标
+func (m *metrics) writeActions(buf *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(m.actions))
+	for name := range m.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	name := m.namespace + "_requests_total"
+	writeMetricsHeader(buf, name, "counter", "Total number of api requests.")
+	for _, action := range names {
+		fmt.Fprintf(buf, "%s{action=\"%s\"} %d\n", name, escapeLabelValue(action), m.actions[action].count)
+	}
+
+	name = m.namespace + "_errors_total"
+	writeMetricsHeader(buf, name, "counter", "Total number of api requests returning error, by error code.")
+	for _, action := range names {
+		am := m.actions[action]
+		codes := make([]string, 0, len(am.errors))
+		for code := range am.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(buf, "%s{action=\"%s\",code=\"%s\"} %d\n", name, escapeLabelValue(action), escapeLabelValue(code), am.errors[code])
+		}
+	}
+
+	name = m.namespace + "_request_duration_seconds"
+	writeMetricsHeader(buf, name, "histogram", "Latency of api requests in seconds.")
+	for _, action := range names {
+		am := m.actions[action]
+		label := escapeLabelValue(action)
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += am.buckets[i]
+			fmt.Fprintf(buf, "%s_bucket{action=\"%s\",le=\"%s\"} %d\n", name, label, formatMetricsFloat(bound), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket{action=\"%s\",le=\"+Inf\"} %d\n", name, label, am.count)
+		fmt.Fprintf(buf, "%s_sum{action=\"%s\"} %s\n", name, label, formatMetricsFloat(am.sum))
+		fmt.Fprintf(buf, "%s_count{action=\"%s\"} %d\n", name, label, am.count)
+	}
+}
+
+// writeDbStats 输出数据库连接池指标
+func (m *metrics) writeDbStats(buf *bytes.Buffer, dbm *DbManager) {
+	engines := dbm.engines()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make([][]string, len(names))
+	for i, name := range names {
+		s := engines[name].DB().Stats()
+		stats[i] = []string{
+			strconv.Itoa(s.MaxOpenConnections),
+			strconv.Itoa(s.OpenConnections),
+			strconv.Itoa(s.InUse),
+			strconv.Itoa(s.Idle),
+			strconv.FormatInt(s.WaitCount, 10),
+			formatMetricsFloat(s.WaitDuration.Seconds()),
+		}
+	}
+
+	defines := [][]string{
+		{"db_max_open_connections", "gauge", "Maximum number of open connections to the database."},
+		{"db_open_connections", "gauge", "The number of established connections both in use and idle."},
+		{"db_in_use_connections", "gauge", "The number of connections currently in use."},
+		{"db_idle_connections", "gauge", "The number of idle connections."},
+		{"db_wait_count_total", "counter", "The total number of connections waited for."},
+		{"db_wait_duration_seconds_total", "counter", "The total time blocked waiting for a new connection."},
+	}
+	for j, define := range defines {
+		name := m.namespace + "_" + define[0]
+		writeMetricsHeader(buf, name, define[1], define[2])
+		for i, db := range names {
+			fmt.Fprintf(buf, "%s{db=\"%s\"} %s\n", name, escapeLabelValue(db), stats[i][j])
+		}
+	}
+}
+
+// metricsAccess 指标接口的访问控制
+type metricsAccess struct {
+	allowIps *trustedProxies // 允许访问的地址
+	token    string          // 不为空时，携带 Authorization: Bearer <token> 的请求也允许访问
+}
+
+// allowed 判断请求是否允许访问指标，只检查直接连接的地址，不读取转发头
+func (a *metricsAccess) allowed(r *http.Request) bool {
+	if a.allowIps.trustsAddr(r.RemoteAddr) {
+		return true
+	}
+	if a.token == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(a.token)) == 1
+}
+
+// newMetricsHandler return the handler of metrics in Prometheus text format.
+func newMetricsHandler(app *App, access *metricsAccess) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !access.allowed(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		buf := new(bytes.Buffer)
+		app.metrics.writeActions(buf)
+		app.metrics.writeDbStats(buf, app.DB)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
+
+// initMetrics 初始化监控指标，配置项：
+//
+//	api.metrics.enabled: 是否启用，默认为 false
+//	api.metrics.path: 指标输出路径，默认为 /metrics
+//	api.metrics.namespace: 指标名称前缀，默认为 apibox
+//	api.metrics.buckets: 请求耗时分布区间（秒）
+//	api.metrics.allow_ips: 允许访问的IP或网段，格式与 api.trusted_proxies 相同，默认只允许本机访问
+//	api.metrics.token: 访问令牌，不为空时携带 Authorization: Bearer <token> 的请求也允许访问
+//
+// 指标接口不经过中间件，不受 app.host 及监听的 host 限制，
+// 访问控制只按直接连接的地址判断，不读取代理的转发头。
+func (app *App) initMetrics(apiPaths []string) {
+	cfg := app.Config
+	if !cfg.GetDefaultBool("api.metrics.enabled", false) {
+		return
+	}
+
+	path := cfg.GetDefaultString("api.metrics.path", "/metrics")
+	if strSliceContains(apiPaths, path) {
+		app.Logger.Warningf("(api) metrics path %s conflicts with api path, ignored.", path)
+		return
+	}
+
+	allowIps, err := parseTrustedProxies(cfg.GetDefaultStringArray("api.metrics.allow_ips", defaultTrustedProxies))
+	if err != nil {
+		app.Logger.Warningf("(api) invalid api.metrics.allow_ips, metrics disabled: %s", err.Error())
+		return
+	}
+	access := &metricsAccess{allowIps, cfg.GetDefaultString("api.metrics.token", "")}
+
+	namespace := cfg.GetDefaultString("api.metrics.namespace", "apibox")
+	buckets := cfg.GetDefaultFloatArray("api.metrics.buckets", defaultMetricsBuckets)
+	app.metrics = newMetrics(namespace, buckets)
+	app.Router.HandleFunc(path, newMetricsHandler(app, access))
+}
+
+func writeMetricsHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatMetricsFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue 转义标签值中的 \ " 和换行
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
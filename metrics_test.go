@@ -0,0 +1,74 @@
+// 监控指标测试
+
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsAccess(t *testing.T) {
+	allowIps := mustTrustedProxies(t, "127.0.0.0/8", "10.0.0.0/8")
+	cases := []struct {
+		token      string
+		remoteAddr string
+		auth       string
+		want       bool
+	}{
+		{"", "127.0.0.1:1234", "", true},
+		{"", "10.1.2.3:1234", "", true},
+		{"", "203.0.113.1:1234", "", false},
+		{"", "203.0.113.1:1234", "Bearer ", false},
+		{"", "@", "", false},
+		{"secret", "203.0.113.1:1234", "Bearer secret", true},
+		{"secret", "203.0.113.1:1234", "bearer secret", true},
+		{"secret", "203.0.113.1:1234", "Bearer other", false},
+		{"secret", "203.0.113.1:1234", "secret", false},
+		{"secret", "203.0.113.1:1234", "", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.RemoteAddr = c.remoteAddr
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		access := &metricsAccess{allowIps, c.token}
+		if got := access.allowed(r); got != c.want {
+			t.Errorf("allowed(token=%q, %s, %q) = %v, want %v", c.token, c.remoteAddr, c.auth, got, c.want)
+		}
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.metrics = newMetrics("test", []float64{1})
+	handler := app.Route([]*Route{
+		NewRoute("Test.Echo", func(c *Context) interface{} { return nil }),
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/?api_action=Test.Echo", nil))
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/?api_action=Test.None", nil))
+	// 无法解析的请求计入 _unknown
+	r := httptest.NewRequest("POST", "/?api_action=Test.Echo", strings.NewReader("{"))
+	r.Header.Set("Content-Type", "application/json")
+	handler(httptest.NewRecorder(), r)
+
+	buf := new(bytes.Buffer)
+	app.metrics.writeActions(buf)
+	out := buf.String()
+	for _, line := range []string{
+		`test_requests_total{action="Test.Echo"} 1`,
+		`test_requests_total{action="_unknown"} 2`,
+		`test_errors_total{action="_unknown",code="ActionNotExist"} 1`,
+		`test_errors_total{action="_unknown",code="InternalError"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics should contain %s, got:\n%s", line, out)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
@@ -15,6 +16,8 @@ import (
 type Params struct {
 	Rules        []*ParamRule
 	RawValues    url.Values
+	RawData      map[string]interface{}   // JSON请求体中的值，优先于RawValues
+	RawFiles     map[string][]*UploadFile // 上传的文件
 	ParsedValues map[string]interface{}
 	Error        *ErrorManager
 }
@@ -25,11 +28,57 @@ type ParamRule struct {
 	Parsed    bool
 }
 
+// ParamError is the error detail of one param, used in the data of error returned by ParseAll.
+type ParamError struct {
+	Param   string
+	Code    string
+	Message string
+}
+
 // NewParams return a new Params object.
 func NewParams(values url.Values, em *ErrorManager) *Params {
 	rules := make([]*ParamRule, 0)
 	parsedValues := make(map[string]interface{})
-	return &Params{rules, values, parsedValues, em}
+	return &Params{rules, values, nil, nil, parsedValues, em}
+}
+
+// rawValue return the raw value of param before filtering.
+// Uploaded files are returned as *UploadFile or []*UploadFile.
+// Values from JSON request body are converted as:
+// string/number/bool => string, scalar array => []string,
+// object and nested array => json string, the same as the json param of form request.
+// The decoded values can be read from RawData.
+func (p *Params) rawValue(paramName string) interface{} {
+	if files, ok := p.RawFiles[paramName]; ok && len(files) > 0 {
+		if len(files) == 1 {
+			return files[0]
+		}
+		return files
+	}
+	if v, ok := p.RawData[paramName]; ok {
+		if v == nil {
+			return nil
+		}
+		if strVals, ok := jsonFormValues(v); ok {
+			if _, isArray := v.([]interface{}); isArray {
+				return strVals
+			}
+			return strVals[0]
+		}
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+		return v
+	}
+
+	vals, ok := p.RawValues[paramName]
+	if !ok {
+		return nil
+	}
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	return []string(vals)
 }
 
 // Add add a new param with filter functions.
@@ -84,19 +133,11 @@ func (p *Params) Del(paramNames ...string) *Params {
 // Validate one param according to the rule and save the parsed value.
 func (p *Params) Validate(paramName string, filters ...filter.Filter) *Error {
 	var val interface{}
-	var ok bool
 	var err *filter.Error
 
 	p.Rules = append(p.Rules, &ParamRule{paramName, filters, true})
 
-	val, ok = p.RawValues[paramName]
-	if ok {
-		if len(p.RawValues[paramName]) == 1 {
-			val = p.RawValues[paramName][0]
-		}
-	} else {
-		val = nil
-	}
+	val = p.rawValue(paramName)
 
 	for _, f := range filters {
 		val, err = f.Run(paramName, val)
@@ -112,7 +153,6 @@ func (p *Params) Validate(paramName string, filters ...filter.Filter) *Error {
 // Parse parse the values according to the rules.
 func (p *Params) Parse() *Error {
 	var val interface{}
-	var ok bool
 	var err *filter.Error
 
 	for _, rule := range p.Rules {
@@ -124,14 +164,7 @@ func (p *Params) Parse() *Error {
 		paramName := rule.ParamName
 		filters := rule.Filters
 
-		val, ok = p.RawValues[paramName]
-		if ok {
-			if len(p.RawValues[paramName]) == 1 {
-				val = p.RawValues[paramName][0]
-			}
-		} else {
-			val = nil
-		}
+		val = p.rawValue(paramName)
 
 		for _, f := range filters {
 			val, err = f.Run(paramName, val)
@@ -154,6 +187,47 @@ func (p *Params) Parse() *Error {
 	return nil
 }
 
+// ParseAll parse the values according to all the rules, and do not stop on error.
+// If any param is invalid, the first error is returned, with data list all
+// the invalid params as []*ParamError.
+func (p *Params) ParseAll() *Error {
+	var firstErr *Error
+	paramErrors := make([]*ParamError, 0)
+
+	for _, rule := range p.Rules {
+		if rule.Parsed {
+			continue
+		}
+		rule.Parsed = true
+
+		paramName := rule.ParamName
+		val := p.rawValue(paramName)
+
+		var err *filter.Error
+		for _, f := range rule.Filters {
+			val, err = f.Run(paramName, val)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			apiErr := p.Error.New(ErrorType(err.Type), err.Fields...)
+			if firstErr == nil {
+				firstErr = apiErr
+			}
+			paramErrors = append(paramErrors, &ParamError{paramName, apiErr.Code, apiErr.Message})
+			continue
+		}
+
+		p.ParsedValues[paramName] = val
+	}
+
+	if firstErr != nil {
+		return firstErr.SetData(paramErrors)
+	}
+	return nil
+}
+
 // Set set the param value.
 func (p *Params) Set(paramName string, paramValue interface{}) *Params {
 	p.ParsedValues[paramName] = paramValue
@@ -391,6 +465,32 @@ func (p *Params) GetTimestampRange(paramName string) *types.TimestampRange {
 	return nil
 }
 
+// GetFile return the parsed value of param as uploaded file.
+func (p *Params) GetFile(paramName string) *UploadFile {
+	v := p.Get(paramName)
+	switch val := v.(type) {
+	case *UploadFile:
+		return val
+	case []*UploadFile:
+		if len(val) > 0 {
+			return val[0]
+		}
+	}
+	return nil
+}
+
+// GetFiles return the parsed value of param as uploaded file array.
+func (p *Params) GetFiles(paramName string) []*UploadFile {
+	v := p.Get(paramName)
+	switch val := v.(type) {
+	case *UploadFile:
+		return []*UploadFile{val}
+	case []*UploadFile:
+		return val
+	}
+	return []*UploadFile{}
+}
+
 // GetStringArray return the parsed value of param as string array.
 func (p *Params) GetStringArray(paramName string) []string {
 	v := p.Get(paramName)
@@ -0,0 +1,203 @@
+// 受信任的代理
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+)
+
+// 默认只信任本机的代理
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "unix"}
+
+// trustedProxies 受信任的代理，只有直接连接来自这些地址时才使用转发头中的客户端IP
+type trustedProxies struct {
+	nets   []*net.IPNet
+	unix   bool   // 是否信任 unix socket 连接
+	header string // 代理设置的转发头，为空时不读取转发头
+}
+
+// loadTrustedProxies 读取受信任的代理，配置项 api.trusted_proxies 为 CIDR 或 IP 列表，
+// unix 表示信任 unix socket 连接，如：
+//
+//	api.trusted_proxies:
+//	  - 10.0.0.0/8
+//	  - 192.168.1.10
+//	  - unix
+//
+// 配置项 api.real_ip_header 为代理设置的转发头，只读取该头部，默认为 X-Real-IP，
+// 可以为 X-Forwarded-For, Forwarded 或其它只包含一个IP的头部，为 none 时不读取转发头。
+// 代理未覆盖的其它转发头可能由客户端伪造，因此不会读取。
+func (app *App) loadTrustedProxies() (*trustedProxies, error) {
+	cfg := app.Config
+	items := cfg.GetDefaultStringArray("api.trusted_proxies", defaultTrustedProxies)
+	p, err := parseTrustedProxies(items)
+	if err != nil {
+		return nil, err
+	}
+	if header := strings.TrimSpace(cfg.GetDefaultString("api.real_ip_header", "X-Real-IP")); header != "none" {
+		p.header = http.CanonicalHeaderKey(header)
+	}
+	return p, nil
+}
+
+func parseTrustedProxies(items []string) (*trustedProxies, error) {
+	p := &trustedProxies{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "unix" {
+			p.unix = true
+			continue
+		}
+		if strings.IndexByte(item, '/') == -1 {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", item)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	return p, nil
+}
+
+// contains 检查IP是否为受信任的代理
+func (p *trustedProxies) contains(ip net.IP) bool {
+	if p == nil || ip == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsAddr 检查直接连接的地址是否受信任，addr 格式同 http.Request.RemoteAddr
+func (p *trustedProxies) trustsAddr(addr string) bool {
+	if addr == "" || addr == "@" {
+		return p != nil && p.unix
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return p.contains(net.ParseIP(host))
+}
+
+// clientIp 返回请求的客户端IP，只读取 api.real_ip_header 指定的转发头，直接连接不受信任时忽略转发头
+func (p *trustedProxies) clientIp(r *http.Request) string {
+	peer := remoteIp(r.RemoteAddr)
+	if p == nil || p.header == "" || !p.trustsAddr(r.RemoteAddr) {
+		return peer
+	}
+	values := r.Header.Values(p.header)
+	if len(values) == 0 {
+		return peer
+	}
+
+	switch p.header {
+	case "Forwarded":
+		return p.chainClientIp(parseForwardedFor(values), peer)
+	case "X-Forwarded-For":
+		return p.chainClientIp(splitHeaderList(values), peer)
+	}
+	// 只包含一个IP的头部，如 X-Real-IP，有多个时以代理最后添加的为准
+	if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// chainClientIp 从右向左跳过受信任的代理，返回第一个不受信任的地址
+// 遇到无法解析的地址时停止，返回其右侧的地址
+func (p *trustedProxies) chainClientIp(hops []string, peer string) string {
+	clientIp := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		clientIp = ip.String()
+		if !p.contains(ip) {
+			break
+		}
+	}
+	return clientIp
+}
+
+// remoteIp 返回 RemoteAddr 中的IP，unix socket 连接返回 @
+func remoteIp(remoteAddr string) string {
+	if remoteAddr == "@" {
+		return "@"
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// splitHeaderList 拆分逗号分隔的头部值
+func splitHeaderList(values []string) []string {
+	items := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
+// parseForwardedFor 返回 Forwarded 头（RFC 7239）中各节点的 for 地址，不含端口
+// 如：for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"
+// 没有 for 或为 unknown 等隐藏标识时返回原值，无法作为IP解析
+func parseForwardedFor(values []string) []string {
+	elements := splitHeaderList(values)
+	hops := make([]string, 0, len(elements))
+	for _, element := range elements {
+		node := ""
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				node = strings.Trim(kv[1], "\"")
+				break
+			}
+		}
+		if strings.HasPrefix(node, "[") {
+			// IPv6 地址在方括号中，可能带端口
+			if end := strings.IndexByte(node, ']'); end != -1 {
+				node = node[1:end]
+			}
+		} else if strings.Count(node, ":") == 1 {
+			node = node[:strings.IndexByte(node, ':')]
+		}
+		hops = append(hops, node)
+	}
+	return hops
+}
+
+// realIpResolver 解析客户端IP并保存在请求中，由 Input.GetRealIp 读取
+type realIpResolver struct {
+	proxies *trustedProxies
+}
+
+func (m *realIpResolver) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	context.Set(r, "real_ip", m.proxies.clientIp(r))
+	next(rw, r)
+}
@@ -0,0 +1,175 @@
+// PROXY协议
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 读取PROXY协议头的超时时间
+const proxyHeaderTimeout = 10 * time.Second
+
+// PROXY协议 v2 签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoListener 解析来自受信任代理的连接的PROXY协议头（v1及v2），
+// 参考：https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+// 不受信任的连接不解析，按原样处理
+type proxyProtoListener struct {
+	net.Listener
+	proxies *trustedProxies
+}
+
+func (ln *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	var addr string
+	if remoteAddr := c.RemoteAddr(); remoteAddr != nil {
+		addr = remoteAddr.String()
+	}
+	if !ln.proxies.trustsAddr(addr) {
+		return c, nil
+	}
+	return &proxyProtoConn{Conn: c}, nil
+}
+
+// proxyProtoConn 首次读取或获取远端地址时解析PROXY协议头，协议头无效时读取返回错误
+type proxyProtoConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	once       sync.Once
+	err        error
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+		c.reader = bufio.NewReader(c.Conn)
+
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		addr, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = err
+			return
+		}
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr return the source address in PROXY protocol header.
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remoteAddr
+}
+
+// readProxyHeader 读取PROXY协议头，返回客户端地址，代理未提供地址时返回nil
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// v1 最短的协议头 "PROXY UNKNOWN\r\n" 也超过 v2 签名长度
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("proxy protocol: missing header")
+}
+
+// readProxyHeaderV1 读取文本格式的协议头，如：PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, errors.New("proxy protocol: invalid v1 header")
+	}
+	// 协议头最长107字节
+	if len(line) > 107 || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol: invalid v1 header")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("proxy protocol: invalid v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errors.New("proxy protocol: invalid v1 source address")
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("proxy protocol: invalid v1 source port")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 读取二进制格式的协议头
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("proxy protocol: unsupported v2 version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch header[12] & 0x0F {
+	case 0x0: // LOCAL，代理自身的连接，如健康检查
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.New("proxy protocol: unsupported v2 command")
+	}
+
+	switch header[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("proxy protocol: invalid v2 address")
+		}
+		return &net.TCPAddr{
+			IP:   net.IPv4(body[0], body[1], body[2], body[3]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("proxy protocol: invalid v2 address")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), body[0:16]...)),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	default: // AF_UNSPEC 或 AF_UNIX
+		return nil, nil
+	}
+}
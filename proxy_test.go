@@ -0,0 +1,214 @@
+// 受信任的代理及PROXY协议测试
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustTrustedProxies(t *testing.T, items ...string) *trustedProxies {
+	t.Helper()
+	p, err := parseTrustedProxies(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseForwardedFor(t *testing.T) {
+	cases := []struct {
+		values []string
+		want   []string
+	}{
+		{[]string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []string{"192.0.2.60"}},
+		{[]string{"for=192.0.2.43, for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{[]string{"for=192.0.2.43", "for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{[]string{`for="[2001:db8:cafe::17]:4711"`}, []string{"2001:db8:cafe::17"}},
+		{[]string{`For="[2001:db8:cafe::17]"`}, []string{"2001:db8:cafe::17"}},
+		{[]string{`for="192.0.2.60:8080"`}, []string{"192.0.2.60"}},
+		{[]string{"proto=https;for=192.0.2.60"}, []string{"192.0.2.60"}},
+		{[]string{"for=unknown, for=192.0.2.60"}, []string{"unknown", "192.0.2.60"}},
+		{[]string{"for=_hidden"}, []string{"_hidden"}},
+		{[]string{"proto=https"}, []string{""}},
+	}
+	for _, c := range cases {
+		if got := parseForwardedFor(c.values); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseForwardedFor(%q) = %q, want %q", c.values, got, c.want)
+		}
+	}
+}
+
+func TestChainClientIp(t *testing.T) {
+	p := mustTrustedProxies(t, "10.0.0.0/8", "192.168.1.10")
+	cases := []struct {
+		hops []string
+		want string
+	}{
+		{[]string{}, "10.0.0.1"},
+		{[]string{"203.0.113.1"}, "203.0.113.1"},
+		// 跳过受信任的代理
+		{[]string{"203.0.113.1", "10.0.0.2", "192.168.1.10"}, "203.0.113.1"},
+		// 客户端伪造的地址在不受信任的节点左侧，不会被采用
+		{[]string{"1.2.3.4", "203.0.113.1", "10.0.0.2"}, "203.0.113.1"},
+		// 无法解析的地址停止，返回其右侧的地址
+		{[]string{"1.2.3.4", "unknown", "10.0.0.2"}, "10.0.0.2"},
+		{[]string{"garbage"}, "10.0.0.1"},
+		// 全部为受信任的代理时返回最左侧的地址
+		{[]string{"10.0.0.3", "10.0.0.2"}, "10.0.0.3"},
+		{[]string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, c := range cases {
+		if got := p.chainClientIp(c.hops, "10.0.0.1"); got != c.want {
+			t.Errorf("chainClientIp(%q) = %q, want %q", c.hops, got, c.want)
+		}
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	cases := []struct {
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"X-Real-IP", "127.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.1"}, "203.0.113.1"},
+		// 不受信任的直接连接忽略转发头
+		{"X-Real-IP", "198.51.100.1:1234", map[string]string{"X-Real-IP": "203.0.113.1"}, "198.51.100.1"},
+		// 只读取配置的头部，其它头部可能由客户端伪造
+		{"X-Real-IP", "127.0.0.1:1234", map[string]string{"Forwarded": "for=1.2.3.4", "X-Real-IP": "203.0.113.1"}, "203.0.113.1"},
+		{"X-Real-IP", "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "127.0.0.1"},
+		{"x-forwarded-for", "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.1"}, "203.0.113.1"},
+		{"Forwarded", "127.0.0.1:1234", map[string]string{"Forwarded": "for=203.0.113.1", "X-Real-IP": "1.2.3.4"}, "203.0.113.1"},
+		{"X-Real-IP", "127.0.0.1:1234", map[string]string{"X-Real-IP": "not an ip"}, "127.0.0.1"},
+		{"", "127.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.1"}, "127.0.0.1"},
+		{"X-Real-IP", "@", map[string]string{"X-Real-IP": "203.0.113.1"}, "203.0.113.1"},
+	}
+	for _, c := range cases {
+		p := mustTrustedProxies(t, defaultTrustedProxies...)
+		if c.header != "" {
+			p.header = http.CanonicalHeaderKey(c.header)
+		}
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := p.clientIp(r); got != c.want {
+			t.Errorf("clientIp(%s, %s, %v) = %q, want %q", c.header, c.remoteAddr, c.headers, got, c.want)
+		}
+	}
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	cases := []struct {
+		header  string
+		want    string // 空表示没有地址
+		wantErr bool
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"PROXY UNKNOWN\r\n", "", false},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", false},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", true},
+		{"PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n", "", true},
+		{"PROXY TCP6 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", true},
+		{"PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443", "", true},
+		{"PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", "", true},
+	}
+	for _, c := range cases {
+		addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(c.header + "GET / HTTP/1.1\r\n")))
+		if (err != nil) != c.wantErr {
+			t.Errorf("readProxyHeader(%q) error = %v, wantErr %v", c.header, err, c.wantErr)
+			continue
+		}
+		if got := addrString(addr); got != c.want {
+			t.Errorf("readProxyHeader(%q) = %q, want %q", c.header, got, c.want)
+		}
+	}
+
+	// 协议头后的数据保持不变
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	if _, err := readProxyHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := r.ReadString('\n'); line != "GET / HTTP/1.1\r\n" {
+		t.Errorf("data after header = %q", line)
+	}
+
+	if _, err := readProxyHeader(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))); err == nil {
+		t.Error("readProxyHeader without header should fail")
+	}
+}
+
+// proxyHeaderV2 生成 v2 协议头
+func proxyHeaderV2(verCmd, famProto byte, addrs []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(verCmd)
+	buf.WriteByte(famProto)
+	binary.Write(buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	// 带 TLV 扩展
+	ipv4Tlv := append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)
+	// 长度超过实际数据
+	truncated := proxyHeaderV2(0x21, 0x11, ipv4)
+	binary.BigEndian.PutUint16(truncated[14:16], 0xffff)
+
+	cases := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{"tcp4", proxyHeaderV2(0x21, 0x11, ipv4), "192.0.2.1:56324", false},
+		{"tcp6", proxyHeaderV2(0x21, 0x21, ipv6), "[2001:db8::1]:56324", false},
+		{"tlv", proxyHeaderV2(0x21, 0x11, ipv4Tlv), "192.0.2.1:56324", false},
+		{"local", proxyHeaderV2(0x20, 0x00, nil), "", false},
+		{"unspec", proxyHeaderV2(0x21, 0x00, nil), "", false},
+		{"unix", proxyHeaderV2(0x21, 0x31, make([]byte, 216)), "", false},
+		{"version", proxyHeaderV2(0x11, 0x11, ipv4), "", true},
+		{"command", proxyHeaderV2(0x22, 0x11, ipv4), "", true},
+		{"short ipv4", proxyHeaderV2(0x21, 0x11, ipv4[:8]), "", true},
+		{"short ipv6", proxyHeaderV2(0x21, 0x21, ipv6[:32]), "", true},
+		{"truncated", truncated, "", true},
+	}
+	for _, c := range cases {
+		r := bufio.NewReader(bytes.NewReader(append(c.header, "GET / HTTP/1.1\r\n"...)))
+		addr, err := readProxyHeader(r)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", c.name, err, c.wantErr)
+			continue
+		}
+		if got := addrString(addr); got != c.want {
+			t.Errorf("%s: addr = %q, want %q", c.name, got, c.want)
+		}
+		if err == nil {
+			if line, _ := r.ReadString('\n'); line != "GET / HTTP/1.1\r\n" {
+				t.Errorf("%s: data after header = %q", c.name, line)
+			}
+		}
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -3,37 +3,147 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/go-apibox/cache"
-	"github.com/streadway/simpleuuid"
+	"github.com/gorilla/context"
 )
 
+// requestTrace 请求的ID及 W3C Trace Context，参考：https://www.w3.org/TR/trace-context/
+type requestTrace struct {
+	requestId    string
+	traceId      string // 32位十六进制
+	spanId       string // 16位十六进制，当前请求
+	parentSpanId string // 16位十六进制，上游请求，没有时为空
+	flags        string // 2位十六进制
+}
+
+// traceparent 返回向下游传递的 traceparent，parent-id 为当前请求
+func (t *requestTrace) traceparent() string {
+	return "00-" + t.traceId + "-" + t.spanId + "-" + t.flags
+}
+
+// getRequestTrace 返回 RequestIdMaker 保存在请求中的 requestTrace，没有时返回nil
+func getRequestTrace(r *http.Request) *requestTrace {
+	t, _ := context.Get(r, "request_trace").(*requestTrace)
+	return t
+}
+
 // RequestIdMaker is a middleware handler that auto generate the request id and append to response header.
+// If TrustIncoming is true, the X-Request-Id and traceparent headers of request will be used.
 type RequestIdMaker struct {
-	reqIdCache *cache.Cache
+	TrustIncoming bool
 }
 
 // NewRequestIdMaker returns a new RequestIdMaker instance
 func NewRequestIdMaker() *RequestIdMaker {
-	return &RequestIdMaker{cache.NewCache(time.Duration(10) * time.Second)}
+	return &RequestIdMaker{false}
 }
 
 func (m *RequestIdMaker) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	var reqId string
-	for {
-		uuid, err := simpleuuid.NewTime(time.Now())
-		if err != nil {
-			continue
+	t := &requestTrace{"", "", newSpanId(), "", "01"}
+	if m.TrustIncoming {
+		if reqId := r.Header.Get("X-Request-Id"); isValidRequestId(reqId) {
+			t.requestId = reqId
 		}
-		reqId = uuid.String()
-		_, exists := m.reqIdCache.Get(reqId)
-		if !exists {
-			break
+		if traceId, parentId, flags, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			t.traceId, t.parentSpanId, t.flags = traceId, parentId, flags
+			// 未传递请求ID时使用 trace-id，便于与上游关联
+			if t.requestId == "" {
+				t.requestId = traceId
+			}
 		}
 	}
-	rw.Header().Set("X-Request-Id", reqId)
+	if t.requestId == "" {
+		t.requestId = NewRequestId()
+	}
+	if t.traceId == "" {
+		t.traceId = newTraceId()
+	}
+	context.Set(r, "request_trace", t)
+	rw.Header().Set("X-Request-Id", t.requestId)
 
 	next(rw, r)
 }
+
+// crockford base32，与 ULID 一致
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewRequestId return a new request id in ULID format: 48 bits milliseconds timestamp and 80 bits random,
+// encoded to 26 chars, such as 01ARZ3NDEKTSV4RRFFQ69G5FAV.
+func NewRequestId() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+	rand.Read(b[6:])
+
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i] = ulidEncoding[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+func newTraceId() string {
+	return randomHex(16)
+}
+
+func newSpanId() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestId 检查传入的请求ID，只允许128个字符以内的字母、数字及 -_.:+=/
+func isValidRequestId(s string) bool {
+	if s == "" || len(s) > 128 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_.:+=/", c) != -1 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// parseTraceparent 解析 traceparent，格式如：00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(s string) (traceId, parentId, flags string, ok bool) {
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return
+	}
+	version := s[0:2]
+	// 版本 00 长度固定，更高版本可能在后面增加字段
+	if !isLowerHex(version) || version == "ff" || version == "00" && len(s) != 55 || len(s) > 55 && s[55] != '-' {
+		return
+	}
+	traceId, parentId, flags = s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceId) || !isLowerHex(parentId) || !isLowerHex(flags) ||
+		traceId == strings.Repeat("0", 32) || parentId == strings.Repeat("0", 16) {
+		return "", "", "", false
+	}
+	return traceId, parentId, flags, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -118,61 +118,74 @@ func WriteResponse(c *Context, data interface{}) {
 			}
 		}
 
-		c.App.Logger.Debugf("\n"+
+		c.Logger().Debugf("\n"+
 			">>>>>>>>>>>>>>>>>>>>> DEBUG >>>>>>>>>>>>>>>>>>>>\n"+
 			"%s\n>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>", string(jsonBytes))
 
 	case "code":
 		apiData := makeData(apiAction, data)
 
-		c.App.Logger.Debugf("DEBUG: %s", apiData.CODE)
+		c.Logger().Debugf("DEBUG: %s", apiData.CODE)
 	}
 
-	WriteData(c.Response(), c.Request(), data, apiAction, apiFormat, apiCallback, apiDebug)
+	// 根据错误类型输出HTTP状态码，jsonp 需保持200，否则浏览器不会执行回调
+	httpStatus := 0
+	if c.App.Config.GetDefaultBool("api.http_status", false) && apiFormat != "jsonp" {
+		if err, ok := data.(*Error); ok {
+			httpStatus = err.HTTPStatus()
+		}
+	}
+
+	writeData(c.Response(), c.Request(), httpStatus, data, apiAction, apiFormat, apiCallback, apiDebug)
 }
 
 func WriteData(w http.ResponseWriter, r *http.Request, data interface{},
 	apiAction string, apiFormat string, apiCallback string, apiDebug string) {
-	apiData := makeData(apiAction, data)
-
-	var jsonBytes []byte
-	jsonBeauty := apiDebug == "1"
+	writeData(w, r, 0, data, apiAction, apiFormat, apiCallback, apiDebug)
+}
 
-	if apiData.CODE == "ok" {
-		result := new(SuccessResult)
-		result.CODE = apiData.CODE
-		result.ACTION = apiData.ACTION
-		result.DATA = apiData.DATA
-		jsonBytes = toJSON(result, jsonBeauty)
-	} else {
-		if apiData.DATA == nil {
-			result := new(ErrorResult)
-			result.CODE = apiData.CODE
-			result.ACTION = apiData.ACTION
-			result.MESSAGE = apiData.MESSAGE
-			jsonBytes = toJSON(result, jsonBeauty)
-		} else {
-			result := new(ErrorResultWithData)
-			result.CODE = apiData.CODE
-			result.ACTION = apiData.ACTION
-			result.MESSAGE = apiData.MESSAGE
-			result.DATA = apiData.DATA
-			jsonBytes = toJSON(result, jsonBeauty)
+// writeData 输出结果，httpStatus 为0时使用默认的200
+func writeData(w http.ResponseWriter, r *http.Request, httpStatus int, data interface{},
+	apiAction string, apiFormat string, apiCallback string, apiDebug string) {
+	result := makeResult(apiAction, data)
+	beauty := apiDebug == "1"
+
+	// 已注册的输出格式，如：json, xml, msgpack, yaml
+	if format, has := getFormat(apiFormat); has {
+		resBytes, err := format.encoder(result, beauty)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		if httpStatus != 0 {
+			w.WriteHeader(httpStatus)
 		}
+		w.Write(resBytes)
+		return
 	}
 
-	if apiFormat == "json" {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(jsonBytes)
-	} else { // jsonp
-		jsonpBytes := make([]byte, 0, len(apiCallback)+len(jsonBytes)+3)
-		jsonpBytes = append(jsonpBytes, []byte(apiCallback)...)
-		jsonpBytes = append(jsonpBytes, '(')
-		jsonpBytes = append(jsonpBytes, jsonBytes...)
-		jsonpBytes = append(jsonpBytes, ')', ';')
-		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
-		w.Write(jsonpBytes)
+	// jsonp
+	jsonBytes := toJSON(result, beauty)
+	jsonpBytes := make([]byte, 0, len(apiCallback)+len(jsonBytes)+3)
+	jsonpBytes = append(jsonpBytes, []byte(apiCallback)...)
+	jsonpBytes = append(jsonpBytes, '(')
+	jsonpBytes = append(jsonpBytes, jsonBytes...)
+	jsonpBytes = append(jsonpBytes, ')', ';')
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Write(jsonpBytes)
+}
+
+// makeResult return the result to output: *SuccessResult, *ErrorResult or *ErrorResultWithData.
+func makeResult(action string, data interface{}) interface{} {
+	apiData := makeData(action, data)
+	if apiData.CODE == "ok" {
+		return &SuccessResult{apiData.ACTION, apiData.CODE, apiData.DATA}
+	}
+	if apiData.DATA == nil {
+		return &ErrorResult{apiData.ACTION, apiData.CODE, apiData.MESSAGE}
 	}
+	return &ErrorResultWithData{apiData.ACTION, apiData.CODE, apiData.MESSAGE, apiData.DATA}
 }
 
 func makeData(action string, data interface{}) *Result {
@@ -0,0 +1,147 @@
+// 平滑重启
+
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// restartHandler 收到 SIGUSR2 信号时平滑重启：
+// 启动新进程并传递监听socket，新进程开始监听后，当前进程处理完请求后退出
+func (app *App) restartHandler() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR2)
+	go func(c chan os.Signal) {
+		for range c {
+			app.Logger.Notice("Caught signal SIGUSR2: restarting.")
+			if err := app.restart(); err != nil {
+				app.Logger.Errorf("restart failed: %s", err.Error())
+				continue
+			}
+			signal.Stop(c)
+
+			// 新进程已接管socket，不能删除unix socket文件
+			for _, l := range app.listeners {
+				if unixLn, ok := l.ln.(*net.UnixListener); ok {
+					unixLn.SetUnlinkOnClose(false)
+				}
+			}
+			app.gracefulShutdown()
+			return
+		}
+	}(sigc)
+}
+
+// restart 启动新进程并等待其开始监听
+func (app *App) restart() error {
+	// ExtraFiles 中的文件在新进程中从 fd 3 开始
+	files := make([]*os.File, 0, len(app.listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	fds := url.Values{}
+	for _, l := range app.listeners {
+		fileLn, ok := l.ln.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return fmt.Errorf("listener %s does not support restart", l.define.Addr)
+		}
+		f, err := fileLn.File()
+		if err != nil {
+			return err
+		}
+		fds.Set(l.define.Addr, strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	execPath, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+
+	childEnvs := map[string]string{
+		"APP_ADDR":           app.Addr,
+		"APP_HOST":           app.Host,
+		"APP_LISTEN_FDS":     fds.Encode(),
+		"APP_READY_FD":       strconv.Itoa(3 + len(files)),
+		"APP_SUPERVISOR_PID": strconv.Itoa(app.supervisorPid),
+	}
+	for key, val := range app.inheritedEnvs {
+		if _, has := childEnvs[key]; !has {
+			childEnvs[key] = val
+		}
+	}
+	// 去掉当前进程中的同名变量，避免新进程读取到旧值
+	env := make([]string, 0, len(os.Environ())+len(childEnvs))
+	for _, kv := range os.Environ() {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if _, has := childEnvs[key]; !has {
+			env = append(env, kv)
+		}
+	}
+	for key, val := range childEnvs {
+		env = append(env, key+"="+val)
+	}
+
+	cmd := exec.Command(execPath, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	// 等待新进程开始监听，新进程退出时管道关闭
+	ready := make(chan bool, 1)
+	go func() {
+		b := make([]byte, 1)
+		n, _ := readyR.Read(b)
+		ready <- n == 1
+	}()
+	timeout := getConfigDuration(app.Config, "app.restart.timeout", 30*time.Second)
+	select {
+	case ok := <-ready:
+		if !ok {
+			cmd.Wait()
+			return fmt.Errorf("new process exited before listening")
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process not ready in %s", timeout)
+	}
+
+	app.Logger.Noticef("new process %d started", cmd.Process.Pid)
+	return nil
+}
+
+// processExists 判断进程是否存在
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,13 @@
+// 平滑重启（windows下不支持）
+
+package api
+
+// restartHandler windows下不支持平滑重启
+func (app *App) restartHandler() {
+	app.Logger.Warning("(api) restart is not supported on windows, ignore app.restart config.")
+}
+
+// processExists 判断进程是否存在
+func processExists(pid int) bool {
+	return false
+}
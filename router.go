@@ -4,23 +4,80 @@ package api
 
 import (
 	"net/http"
+	"reflect"
+	"time"
 )
 
 type ActionFunc func(c *Context) (data interface{})
 
 type Route struct {
-	ActionCode string
-	ActionFunc ActionFunc
-	Hooks      map[string][]ActionFunc
+	ActionCode  string
+	ActionFunc  ActionFunc
+	Hooks       map[string][]ActionFunc
+	UploadLimit *UploadLimit
+	Summary     string                // 接口说明，用于生成文档
+	Params      *Params               // 接口参数规则，执行前校验并用于生成文档
+	bindFields  map[string]*bindField // 由绑定结构声明的参数，含取值范围
 }
 
 // NewRoute return a new route.
 func NewRoute(actionCode string, actionFunc ActionFunc) *Route {
 	return &Route{
-		actionCode, actionFunc, make(map[string][]ActionFunc),
+		actionCode, actionFunc, make(map[string][]ActionFunc), nil,
+		"", nil, nil,
 	}
 }
 
+// SetSummary set the summary of action used in api spec.
+func (r *Route) SetSummary(summary string) *Route {
+	r.Summary = summary
+	return r
+}
+
+// SetParams declare the param rules of action used in api spec.
+// The params are parsed by the rules before the action is run when serving,
+// the parsed params can be got by Context.RouteParams.
+func (r *Route) SetParams(params *Params) *Route {
+	r.Params = params
+	return r
+}
+
+// SetBind declare the param rules of action with the struct used by Params.Bind.
+// v should be a struct or a pointer to a struct.
+func (r *Route) SetBind(v interface{}) *Route {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return r
+	}
+	fields, err := getBindFields(t, nil)
+	if err != nil {
+		return r
+	}
+
+	params := NewParams(nil, nil)
+	r.bindFields = make(map[string]*bindField, len(fields))
+	for _, bf := range fields {
+		filters, err := bf.filters(t.FieldByIndex(bf.index).Type)
+		if err != nil {
+			continue
+		}
+		params.Add(bf.paramName, filters...)
+		r.bindFields[bf.paramName] = bf
+	}
+	r.Params = params
+	return r
+}
+
+// SetUploadLimit set the size limits of multipart request for this action.
+// The limits can only be stricter than the global api.upload config.
+func (r *Route) SetUploadLimit(maxSize, maxFileSize int64) *Route {
+	r.UploadLimit = &UploadLimit{maxSize, maxFileSize}
+	return r
+}
+
 // Hook add set hook action at specified tag.
 func (r *Route) Hook(tag string, actionFunc ActionFunc) *Route {
 	if _, has := r.Hooks[tag]; !has {
@@ -54,19 +111,34 @@ func newApiHandler(app *App, routes []*Route) func(w http.ResponseWriter, r *htt
 		}
 
 		var resData interface{}
+		metricsAction := metricsUnknownAction
+		start := time.Now()
+		span := app.tracer.startRequestSpan(r, "api")
+		defer span.End()
 
 		ctx, err := NewContext(app, w, r)
 		if err != nil {
+			// 无法解析的请求也计入指标
+			app.metrics.observe(metricsAction, NewError("InternalError", err.Error()), time.Since(start))
+			span.SetError(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer func() {
+			app.metrics.observe(metricsAction, resData, time.Since(start))
+			span.setResult(resData)
+		}()
 		// 清理context操作移至handler最外层
 		// defer ctx.Clear()
 
+		// 清理上传的临时文件
+		defer ctx.Input.RemoveFiles()
+
 		// 系统维护中
 		if app.UnderMaintenance {
-			resData := ctx.Error.NewGroupError("global", errorSystemMaintenance)
+			resData = ctx.Error.NewGroupError("global", errorSystemMaintenance)
 			WriteResponse(ctx, resData)
+			return
 		}
 
 		apiAction := ctx.Input.GetAction()
@@ -79,62 +151,94 @@ func newApiHandler(app *App, routes []*Route) func(w http.ResponseWriter, r *htt
 			resData = ctx.Error.NewGroupError("global", errorActionNotExist)
 			goto output
 		} else {
-			// Action之前的操作
-			if beforeActions, has := app.Hooks["BeforeAction"]; has {
-				if beforeActions != nil && len(beforeActions) > 0 {
-					for _, beforeAction := range beforeActions {
-						data := beforeAction(ctx)
-						if data != nil {
-							resData = data
-							goto output
-						}
-					}
-				}
+			metricsAction = route.ActionCode
+			span.SetAttribute("api.action", route.ActionCode)
+
+			// 上传内容检查
+			if ctx.Input.uploadErr != nil {
+				resData = ctx.Error.New(ErrorType(ctx.Input.uploadErr.Type), ctx.Input.uploadErr.Fields...)
+				goto output
 			}
-			if beforeActions, has := route.Hooks["BeforeAction"]; has {
-				if beforeActions != nil && len(beforeActions) > 0 {
-					for _, beforeAction := range beforeActions {
-						data := beforeAction(ctx)
-						if data != nil {
-							resData = data
-							goto output
-						}
-					}
+			if route.UploadLimit != nil {
+				if err := route.UploadLimit.check(ctx.Input); err != nil {
+					resData = ctx.Error.New(ErrorType(err.Type), err.Fields...)
+					goto output
 				}
 			}
 
-			if route.ActionFunc != nil {
-				// 执行 action
-				resData = route.ActionFunc(ctx)
-			}
+			resData = runAction(app, ctx, route)
+		}
 
-			// Action之后的操作
-			if afterActions, has := route.Hooks["AfterAction"]; has {
-				if afterActions != nil && len(afterActions) > 0 {
-					for _, afterAction := range afterActions {
-						ctx.Set("result", resData)
-						data := afterAction(ctx)
-						if resData == nil && data != nil {
-							resData = data
-						}
-					}
+	output:
+		// 输出结果
+		WriteResponse(ctx, resData)
+	}
+}
+
+// runAction run the action of route with the BeforeAction and AfterAction hooks.
+func runAction(app *App, ctx *Context, route *Route) (resData interface{}) {
+	// Action之前的操作
+	if beforeActions, has := app.Hooks["BeforeAction"]; has {
+		if beforeActions != nil && len(beforeActions) > 0 {
+			for _, beforeAction := range beforeActions {
+				data := ctx.runInSpan("BeforeAction", func() interface{} { return beforeAction(ctx) })
+				if data != nil {
+					return data
 				}
 			}
-			if afterActions, has := app.Hooks["AfterAction"]; has {
-				if afterActions != nil && len(afterActions) > 0 {
-					for _, afterAction := range afterActions {
-						ctx.Set("result", resData)
-						data := afterAction(ctx)
-						if resData == nil && data != nil {
-							resData = data
-						}
-					}
+		}
+	}
+	if beforeActions, has := route.Hooks["BeforeAction"]; has {
+		if beforeActions != nil && len(beforeActions) > 0 {
+			for _, beforeAction := range beforeActions {
+				data := ctx.runInSpan("BeforeAction", func() interface{} { return beforeAction(ctx) })
+				if data != nil {
+					return data
 				}
 			}
 		}
+	}
 
-	output:
-		// 输出结果
-		WriteResponse(ctx, resData)
+	// 按声明的参数规则解析参数，保证实际的校验与文档一致
+	if route.Params != nil {
+		params := ctx.NewParams()
+		for _, rule := range route.Params.Rules {
+			params.Add(rule.ParamName, rule.Filters...)
+		}
+		if err := params.Parse(); err != nil {
+			return err
+		}
+		ctx.Set("route_params", params)
 	}
+
+	if route.ActionFunc != nil {
+		// 执行 action
+		resData = ctx.runInSpan(route.ActionCode, func() interface{} { return route.ActionFunc(ctx) })
+	}
+
+	// Action之后的操作
+	if afterActions, has := route.Hooks["AfterAction"]; has {
+		if afterActions != nil && len(afterActions) > 0 {
+			for _, afterAction := range afterActions {
+				ctx.Set("result", resData)
+				data := ctx.runInSpan("AfterAction", func() interface{} { return afterAction(ctx) })
+				if resData == nil && data != nil {
+					resData = data
+				}
+			}
+		}
+	}
+	if afterActions, has := app.Hooks["AfterAction"]; has {
+		if afterActions != nil && len(afterActions) > 0 {
+			for _, afterAction := range afterActions {
+				ctx.Set("result", resData)
+				data := ctx.runInSpan("AfterAction", func() interface{} { return afterAction(ctx) })
+				if resData == nil && data != nil {
+					resData = data
+				}
+			}
+		}
+	}
+
+	return resData
 }
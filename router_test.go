@@ -0,0 +1,40 @@
+// 路由测试
+
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-apibox/filter"
+)
+
+func TestRouteParams(t *testing.T) {
+	app, err := NewAppFromYaml("app:\n  name: test\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := NewRoute("Foo", func(c *Context) interface{} {
+		return c.RouteParams().GetInt("Page")
+	})
+	route.SetParams(NewParams(nil, nil).Add("Page", filter.Default(1), filter.Int().Min(1)))
+	handler := app.Route([]*Route{route})
+
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"", `"CODE":"ok","DATA":1}`},
+		{"&Page=3", `"CODE":"ok","DATA":3}`},
+		// 声明的规则在执行 action 前校验
+		{"&Page=0", `"CODE":"InvalidParam:Page:TooSmall"`},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/?api_action=Foo"+c.query, nil))
+		if body := w.Body.String(); !strings.Contains(body, c.want) {
+			t.Errorf("query %q: body = %s, want %s", c.query, body, c.want)
+		}
+	}
+}
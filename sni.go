@@ -0,0 +1,135 @@
+// 按SNI选择证书
+
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// sniCert 指定域名使用的证书
+type sniCert struct {
+	hosts    []string // 域名列表，支持通配符，如：*.example.com
+	reloader *certReloader
+}
+
+// match 判断域名是否匹配，通配符只匹配一级子域名
+func (c *sniCert) match(serverName string) bool {
+	for _, host := range c.hosts {
+		if host == serverName {
+			return true
+		}
+		if strings.HasPrefix(host, "*.") {
+			pos := strings.IndexByte(serverName, '.')
+			if pos > 0 && serverName[pos:] == host[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getSNICerts 返回 app.tls.certs 配置的证书列表，配置格式如：
+//
+//	app.tls.certs:
+//	  - hosts: a.example.com,b.example.com
+//	    cert: certs/a.crt
+//	    key: certs/a.key
+//	  - hosts: [ "*.example.net" ]
+//	    cert: certs/example.net.crt
+//	    key: certs/example.net.key
+//
+// 证书文件支持热加载，加载失败的证书会被忽略，对应域名使用默认证书。
+func (app *App) getSNICerts() ([]*sniCert, error) {
+	app.sniCertsOnce.Do(func() {
+		app.sniCerts, app.sniCertsErr = app.loadSNICerts()
+	})
+	return app.sniCerts, app.sniCertsErr
+}
+
+func (app *App) loadSNICerts() ([]*sniCert, error) {
+	v, err := app.Config.Get("app.tls.certs")
+	if err != nil || v == nil {
+		return nil, nil
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("app.tls.certs should be a list")
+	}
+	certs := make([]*sniCert, 0, len(items))
+	for i, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("app.tls.certs[%d] should be a map", i)
+		}
+		var hosts []string
+		var certFile, keyFile string
+		for key, val := range m {
+			switch key {
+			case "hosts":
+				hosts = parseHostList(val)
+			case "cert":
+				certFile = fmt.Sprint(val)
+			case "key":
+				keyFile = fmt.Sprint(val)
+			}
+		}
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("app.tls.certs[%d].hosts is missing", i)
+		}
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("app.tls.certs[%d].cert or key is missing", i)
+		}
+
+		r, err := newCertReloader(app, certFile, keyFile)
+		if err != nil {
+			// 加载失败，不停止服务，对应域名使用默认证书
+			app.Logger.Errorf("(api) load cert of %s failed: %s", strings.Join(hosts, ","), err.Error())
+			continue
+		}
+		go r.watch()
+		certs = append(certs, &sniCert{hosts, r})
+	}
+	return certs, nil
+}
+
+// parseHostList 解析域名列表，支持列表或逗号分隔的字符串，统一转为小写
+func parseHostList(val interface{}) []string {
+	var list []string
+	switch v := val.(type) {
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, strings.Split(fmt.Sprint(item), ",")...)
+		}
+	case nil:
+	default:
+		list = strings.Split(fmt.Sprint(v), ",")
+	}
+
+	hosts := make([]string, 0, len(list))
+	for _, host := range list {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// sniGetCertificate 返回按SNI选择证书的函数，没有匹配的证书时使用默认证书
+func sniGetCertificate(certs []*sniCert,
+	defaultGetter func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		serverName := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if serverName != "" {
+			for _, c := range certs {
+				if c.match(serverName) {
+					return c.reloader.GetCertificate(hello)
+				}
+			}
+		}
+		return defaultGetter(hello)
+	}
+}
@@ -0,0 +1,522 @@
+// OpenAPI文档生成
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-apibox/filter"
+	"gopkg.in/yaml.v2"
+)
+
+// BuildSpec return the OpenAPI 3 document of application.
+// The document is generated from routes, param rules declared by
+// Route.SetParams/Route.SetBind, registered models and error defines.
+// All actions share the api path, the request body and result of each action
+// are declared as schemas named <Action>.Request and <Action>.Result,
+// and distinguished by api_action and ACTION.
+func (app *App) BuildSpec() map[string]interface{} {
+	cfg := app.Config
+	apiPath := cfg.GetDefaultString("api.path", "/")
+
+	schemas := map[string]interface{}{
+		"ErrorResult": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"ACTION":  map[string]interface{}{"type": "string"},
+				"CODE":    map[string]interface{}{"type": "string"},
+				"MESSAGE": map[string]interface{}{"type": "string"},
+				"DATA":    map[string]interface{}{},
+			},
+			"required": []string{"ACTION", "CODE", "MESSAGE"},
+		},
+	}
+	sb := app.newSchemaBuilder()
+	for modelName, model := range app.Model.Models {
+		schemas[modelName] = sb.modelSchema(model.Type)
+	}
+
+	paths := map[string]interface{}{}
+	if len(app.Routes) > 0 {
+		// 所有接口共用同一路径，以 api_action 区分
+		paths[apiPath] = map[string]interface{}{
+			"post": app.actionsSpec(schemas),
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   cfg.GetDefaultString("api.spec.title", app.Name),
+			"version": cfg.GetDefaultString("api.spec.version", "1.0.0"),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+		"x-errors": errorsSpec(app.Error),
+	}
+	if server := cfg.GetDefaultString("api.spec.server", ""); server != "" {
+		doc["servers"] = []interface{}{map[string]interface{}{"url": server}}
+	}
+	return doc
+}
+
+// WriteSpec write the OpenAPI 3 document to file.
+// The document is written as yaml if file extension is .yaml or .yml, otherwise as json.
+func (app *App) WriteSpec(file string) error {
+	var specBytes []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		specBytes, err = yaml.Marshal(app.BuildSpec())
+	default:
+		specBytes, err = json.MarshalIndent(app.BuildSpec(), "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, specBytes, 0644)
+}
+
+// actionsSpec return the operation object of api path.
+// The request body and result schemas of actions are added to schemas.
+func (app *App) actionsSpec(schemas map[string]interface{}) map[string]interface{} {
+	requests := make([]interface{}, 0, len(app.Routes))
+	requestMapping := make(map[string]string, len(app.Routes))
+	results := make([]interface{}, 0, len(app.Routes))
+	resultMapping := make(map[string]string, len(app.Routes))
+	hasFile := false
+	for _, route := range app.Routes {
+		action := route.ActionCode
+		if _, has := requestMapping[action]; has {
+			continue
+		}
+
+		requestSchema, isFile := app.routeRequestSchema(route)
+		requestRef := "#/components/schemas/" + action + ".Request"
+		schemas[action+".Request"] = requestSchema
+		requests = append(requests, map[string]interface{}{"$ref": requestRef})
+		requestMapping[action] = requestRef
+		hasFile = hasFile || isFile
+
+		resultRef := "#/components/schemas/" + action + ".Result"
+		schemas[action+".Result"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"ACTION": map[string]interface{}{"type": "string", "enum": []string{action}},
+				"CODE":   map[string]interface{}{"type": "string", "enum": []string{"ok"}},
+				"DATA":   app.actionDataSchema(action),
+			},
+			"required": []string{"ACTION", "CODE"},
+		}
+		results = append(results, map[string]interface{}{"$ref": resultRef})
+		resultMapping[action] = resultRef
+	}
+
+	bodySchema := map[string]interface{}{
+		"oneOf": requests,
+		"discriminator": map[string]interface{}{
+			"propertyName": "api_action",
+			"mapping":      requestMapping,
+		},
+	}
+	content := map[string]interface{}{
+		"application/x-www-form-urlencoded": map[string]interface{}{"schema": bodySchema},
+		"application/json":                  map[string]interface{}{"schema": bodySchema},
+	}
+	if hasFile {
+		content["multipart/form-data"] = map[string]interface{}{"schema": bodySchema}
+	}
+
+	return map[string]interface{}{
+		"operationId": "callAction",
+		"summary":     "Call the action specified by api_action",
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content":  content,
+		},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"oneOf": results,
+							"discriminator": map[string]interface{}{
+								"propertyName": "ACTION",
+								"mapping":      resultMapping,
+							},
+						},
+					},
+				},
+			},
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/ErrorResult"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// routeRequestSchema return the request body schema of route, isFile is true if any param is file.
+func (app *App) routeRequestSchema(route *Route) (schema map[string]interface{}, isFile bool) {
+	action := route.ActionCode
+	properties := map[string]interface{}{
+		"api_action": map[string]interface{}{"type": "string", "enum": []string{action}},
+	}
+	required := []string{"api_action"}
+	if route.Params != nil {
+		for _, rule := range route.Params.Rules {
+			prop, isRequired, isFileParam := paramSchema(rule.Filters, route.bindFields[rule.ParamName])
+			properties[rule.ParamName] = prop
+			if isRequired {
+				required = append(required, rule.ParamName)
+			}
+			isFile = isFile || isFileParam
+		}
+	}
+
+	schema = map[string]interface{}{
+		"type":       "object",
+		"title":      action,
+		"properties": properties,
+		"required":   required,
+	}
+	if route.Summary != "" {
+		schema["description"] = route.Summary
+	}
+	return
+}
+
+// actionDataSchema return the schema of DATA in result.
+// Actions named as Model.List and Model.Detail/Model.Get of registered models
+// are treated as the result of List and Detail dbop.
+func (app *App) actionDataSchema(action string) map[string]interface{} {
+	parts := strings.SplitN(action, ".", 2)
+	if len(parts) != 2 {
+		return map[string]interface{}{}
+	}
+	model := app.Model.Get(parts[0])
+	if model == nil {
+		return map[string]interface{}{}
+	}
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + parts[0]}
+
+	switch parts[1] {
+	case "List":
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"PageNumber":                 map[string]interface{}{"type": "integer"},
+				"PageSize":                   map[string]interface{}{"type": "integer"},
+				"TotalCount":                 map[string]interface{}{"type": "integer"},
+				"PageCount":                  map[string]interface{}{"type": "integer"},
+				model.MainModelName + "List": map[string]interface{}{"type": "array", "items": ref},
+			},
+		}
+	case "Detail", "Get":
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				model.MainModelName: ref,
+			},
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// paramSchema return the schema of param according to its filters.
+func paramSchema(filters []filter.Filter, bf *bindField) (schema map[string]interface{}, required bool, isFile bool) {
+	schema = map[string]interface{}{}
+	var def interface{}
+	for _, f := range filters {
+		switch f.(type) {
+		case *filter.RequiredFilter:
+			required = true
+		case *filter.DefaultFilter:
+			def, _ = f.Run("", nil)
+		case *filter.EmptyToNilFilter:
+		case boolFilter:
+			schema["type"] = "boolean"
+		case *FileFilter:
+			schema["type"] = "string"
+			schema["format"] = "binary"
+			isFile = true
+		default:
+			for k, v := range filterSchema(f) {
+				schema[k] = v
+			}
+		}
+	}
+
+	if def != nil {
+		schema["default"] = def
+		// 字符串形式的默认值转为与类型一致
+		if s, ok := def.(string); ok {
+			switch schema["type"] {
+			case "integer", "number":
+				if n, err := strconv.ParseFloat(s, 64); err == nil {
+					schema["default"] = n
+				}
+			case "boolean":
+				if b, err := strconv.ParseBool(s); err == nil {
+					schema["default"] = b
+				}
+			}
+		}
+	}
+	if bf != nil {
+		applyBindSchema(schema, bf)
+	}
+	return
+}
+
+// filterSchema return the schema of value filtered by filter.
+func filterSchema(f filter.Filter) map[string]interface{} {
+	name := strings.TrimPrefix(reflect.TypeOf(f).String(), "*filter.")
+	name = strings.TrimSuffix(name, "Filter")
+
+	// 集合，如：IntSet, StringSet
+	if strings.HasSuffix(name, "Set") {
+		itemName := strings.TrimSuffix(name, "Set")
+		items := scalarFilterSchema(itemName)
+		probeSchema(items, f, itemName, "Item")
+		return map[string]interface{}{
+			"type":  "array",
+			"items": items,
+		}
+	}
+	// 范围，如：IntRange，格式为：[1,10)
+	if strings.HasSuffix(name, "Range") {
+		return map[string]interface{}{
+			"type":   "string",
+			"format": "range",
+		}
+	}
+	schema := scalarFilterSchema(name)
+	probeSchema(schema, f, name, "")
+	return schema
+}
+
+func scalarFilterSchema(name string) map[string]interface{} {
+	switch name {
+	case "String", "Time":
+		return map[string]interface{}{"type": "string"}
+	case "Email":
+		return map[string]interface{}{"type": "string", "format": "email"}
+	case "IP":
+		return map[string]interface{}{"type": "string", "format": "ip"}
+	case "CIDR":
+		return map[string]interface{}{"type": "string", "format": "cidr"}
+	case "Int", "Int32":
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case "Int64":
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case "Uint", "Uint32", "Timestamp":
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case "Uint64":
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case "Float32":
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case "Float64":
+		return map[string]interface{}{"type": "number", "format": "double"}
+	}
+	return map[string]interface{}{}
+}
+
+// applyBindSchema add the constraints declared by param tag to schema.
+func applyBindSchema(schema map[string]interface{}, bf *bindField) {
+	target := schema
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		target = items
+	}
+	if schema["format"] == "binary" {
+		return
+	}
+
+	typ, _ := target["type"].(string)
+	switch typ {
+	case "string":
+		if n, err := strconv.Atoi(bf.min); err == nil {
+			target["minLength"] = n
+		}
+		if n, err := strconv.Atoi(bf.max); err == nil {
+			target["maxLength"] = n
+		}
+		if bf.regexp != "" {
+			target["pattern"] = bf.regexp
+		}
+		if bf.enum != nil {
+			target["enum"] = bf.enum
+		}
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(bf.min, 64); err == nil {
+			target["minimum"] = n
+		}
+		if n, err := strconv.ParseFloat(bf.max, 64); err == nil {
+			target["maximum"] = n
+		}
+		if bf.enum != nil {
+			enum := make([]interface{}, 0, len(bf.enum))
+			for _, s := range bf.enum {
+				if n, err := strconv.ParseFloat(s, 64); err == nil {
+					enum = append(enum, n)
+				}
+			}
+			target["enum"] = enum
+		}
+	}
+}
+
+// schemaBuilder 生成go类型的schema，已注册的模型引用 components 中的定义
+type schemaBuilder struct {
+	models   map[reflect.Type]string // 已注册模型的类型 => 名称
+	visiting map[reflect.Type]bool   // 正在展开的结构，防止自引用时无限递归
+}
+
+func (app *App) newSchemaBuilder() *schemaBuilder {
+	sb := &schemaBuilder{
+		models:   make(map[reflect.Type]string, len(app.Model.Models)),
+		visiting: make(map[reflect.Type]bool),
+	}
+	for modelName, model := range app.Model.Models {
+		sb.models[model.Type] = modelName
+	}
+	return sb
+}
+
+// modelSchema return the schema of registered model, the model itself is expanded.
+func (sb *schemaBuilder) modelSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return sb.typeSchema(t)
+	}
+	return sb.structSchema(t)
+}
+
+// typeSchema return the schema of go type.
+func (sb *schemaBuilder) typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case ipType:
+		return map[string]interface{}{"type": "string", "format": "ip"}
+	}
+	if modelName, ok := sb.models[t]; ok {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + modelName}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": sb.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object"}
+	case reflect.Struct:
+		// 未注册的结构自引用时不再展开
+		if sb.visiting[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		return sb.structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+func (sb *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	sb.visiting[t] = true
+	defer delete(sb.visiting, t)
+
+	properties := map[string]interface{}{}
+	sb.addStructProperties(properties, t)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addStructProperties 添加结构的字段，匿名结构展开
+func (sb *schemaBuilder) addStructProperties(properties map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			sb.addStructProperties(properties, field.Type)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		properties[name] = sb.typeSchema(field.Type)
+	}
+}
+
+// errorsSpec return the error catalog of error manager.
+func errorsSpec(em *ErrorManager) []interface{} {
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	groups := make([]string, 0, len(em.groupDefines))
+	for group := range em.groupDefines {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	errors := []interface{}{}
+	for _, group := range groups {
+		defines := em.groupDefines[group]
+		errTypes := make([]int, 0, len(defines))
+		for errType := range defines {
+			errTypes = append(errTypes, int(errType))
+		}
+		sort.Ints(errTypes)
+
+		for _, errType := range errTypes {
+			define := defines[ErrorType(errType)]
+			e := map[string]interface{}{
+				"group":    group,
+				"code":     define.code,
+				"messages": define.msgTmpls,
+			}
+			if define.httpStatus != 0 {
+				e["httpStatus"] = define.httpStatus
+			}
+			errors = append(errors, e)
+		}
+	}
+	return errors
+}
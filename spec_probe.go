@@ -0,0 +1,204 @@
+// 探测过滤器的取值范围及时间格式
+
+package api
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-apibox/filter"
+)
+
+// 探测的字符串长度上限，超过此长度的限制不再探测
+const maxProbeLength = 1 << 16
+
+// probeDomain 有序的候选值，序号越大值越大
+type probeDomain struct {
+	lo, hi uint64
+	value  func(i uint64) string      // 第 i 个候选值
+	number func(s string) interface{} // 候选值转为 schema 中的数值
+}
+
+func intDomain(bits uint) probeDomain {
+	return probeDomain{0, uint64(math.MaxUint64) >> (64 - bits),
+		func(i uint64) string {
+			// 偏移编码转为有符号数
+			v := int64((i^1<<(bits-1))<<(64-bits)) >> (64 - bits)
+			return strconv.FormatInt(v, 10)
+		},
+		func(s string) interface{} {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			return n
+		},
+	}
+}
+
+func uintDomain(bits uint) probeDomain {
+	return probeDomain{0, uint64(math.MaxUint64) >> (64 - bits),
+		func(i uint64) string {
+			return strconv.FormatUint(i, 10)
+		},
+		func(s string) interface{} {
+			n, _ := strconv.ParseUint(s, 10, 64)
+			return n
+		},
+	}
+}
+
+func floatDomain(bits uint) probeDomain {
+	sign := uint64(1) << (bits - 1)
+	mask := uint64(math.MaxUint64) >> (64 - bits)
+	// 浮点数的二进制位转为有序的序号：负数取反，正数翻转符号位
+	index := func(b uint64) uint64 {
+		if b&sign != 0 {
+			return ^b & mask
+		}
+		return b | sign
+	}
+	toFloat := func(i uint64) float64 {
+		b := i ^ sign
+		if i&sign == 0 {
+			b = ^i & mask
+		}
+		if bits == 32 {
+			return float64(math.Float32frombits(uint32(b)))
+		}
+		return math.Float64frombits(b)
+	}
+
+	lo, hi := index(math.Float64bits(-math.MaxFloat64)), index(math.Float64bits(math.MaxFloat64))
+	if bits == 32 {
+		lo, hi = index(uint64(math.Float32bits(-math.MaxFloat32))), index(uint64(math.Float32bits(math.MaxFloat32)))
+	}
+	return probeDomain{lo, hi,
+		func(i uint64) string {
+			return strconv.FormatFloat(toFloat(i), 'g', -1, int(bits))
+		},
+		func(s string) interface{} {
+			n, _ := strconv.ParseFloat(s, 64)
+			return n
+		},
+	}
+}
+
+func lengthDomain() probeDomain {
+	return probeDomain{0, maxProbeLength,
+		func(i uint64) string {
+			return strings.Repeat("a", int(i))
+		},
+		func(s string) interface{} {
+			return len(s)
+		},
+	}
+}
+
+// numberDomains 数值过滤器的候选值
+var numberDomains = map[string]probeDomain{
+	"Int":       intDomain(strconv.IntSize),
+	"Int32":     intDomain(32),
+	"Int64":     intDomain(64),
+	"Uint":      uintDomain(strconv.IntSize),
+	"Uint32":    uintDomain(32),
+	"Uint64":    uintDomain(64),
+	"Timestamp": uintDomain(32),
+	"Float32":   floatDomain(32),
+	"Float64":   floatDomain(64),
+}
+
+// timeLayouts 时间过滤器可能使用的格式，format 为 OpenAPI 中对应的格式
+var timeLayouts = []struct {
+	layout string
+	format string
+}{
+	{"2006-01-02", "date"},
+	{time.RFC3339, "date-time"},
+	{"2006-01-02 15:04:05", ""},
+}
+
+// probeBound return the boundary value accepted by filter in domain.
+// errField is the error field returned when value exceeds the boundary, such as TooSmall.
+// If lower is true, the smallest value not exceeding the lower boundary is returned,
+// otherwise the largest value not exceeding the upper boundary is returned.
+func probeBound(f filter.Filter, d probeDomain, errField string, lower bool) (string, bool) {
+	// 过滤器的限制保存在闭包中，无法直接读取，只能运行过滤器二分查找
+	exceeds := func(i uint64) bool {
+		_, err := f.Run("", d.value(i))
+		return err != nil && err.Fields[len(err.Fields)-1] == errField
+	}
+
+	lo, hi := d.lo, d.hi
+	if lower {
+		if !exceeds(lo) || exceeds(hi) {
+			return "", false
+		}
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if exceeds(mid) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return d.value(hi), true
+	}
+
+	if !exceeds(hi) || exceeds(lo) {
+		return "", false
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if exceeds(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return d.value(lo), true
+}
+
+// probeSchema add the limits of filter to schema, prefix is Item for set filters.
+func probeSchema(schema map[string]interface{}, f filter.Filter, name string, prefix string) {
+	switch name {
+	case "String":
+		d := lengthDomain()
+		if s, ok := probeBound(f, d, prefix+"TooShort", true); ok {
+			schema["minLength"] = d.number(s)
+		}
+		if s, ok := probeBound(f, d, prefix+"TooLong", false); ok {
+			schema["maxLength"] = d.number(s)
+		}
+
+	case "Time":
+		sample := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		for _, l := range timeLayouts {
+			// 超出时间范围的错误也说明格式正确
+			_, err := f.Run("", sample.Format(l.layout))
+			if err != nil && strings.HasPrefix(err.Fields[len(err.Fields)-1], "Not") {
+				continue
+			}
+			if l.format != "" {
+				schema["format"] = l.format
+			}
+			schema["x-layout"] = l.layout
+			break
+		}
+
+	default:
+		d, ok := numberDomains[name]
+		if !ok {
+			return
+		}
+		tooSmall, tooLarge := prefix+"TooSmall", prefix+"TooLarge"
+		if name == "Timestamp" {
+			tooSmall, tooLarge = prefix+"TooEarly", prefix+"TooLate"
+		}
+		if s, ok := probeBound(f, d, tooSmall, true); ok {
+			schema["minimum"] = d.number(s)
+		}
+		if s, ok := probeBound(f, d, tooLarge, false); ok {
+			schema["maximum"] = d.number(s)
+		}
+	}
+}
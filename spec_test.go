@@ -0,0 +1,52 @@
+// OpenAPI文档生成测试
+
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-apibox/filter"
+)
+
+func TestParamSchema(t *testing.T) {
+	cases := []struct {
+		name    string
+		filters []filter.Filter
+		want    map[string]interface{}
+	}{
+		{"int", []filter.Filter{filter.Default(1), filter.Int().Min(1).Max(100)},
+			map[string]interface{}{"type": "integer", "format": "int32", "default": 1, "minimum": int64(1), "maximum": int64(100)}},
+		{"int64 negative", []filter.Filter{filter.Int64().Min(-5)},
+			map[string]interface{}{"type": "integer", "format": "int64", "minimum": int64(-5)}},
+		{"uint32", []filter.Filter{filter.Default("3"), filter.Uint32().Max(10)},
+			map[string]interface{}{"type": "integer", "format": "int64", "default": float64(3), "minimum": 0, "maximum": uint64(10)}},
+		{"float32", []filter.Filter{filter.Float32().Min(0.1).Max(2.5)},
+			map[string]interface{}{"type": "number", "format": "float", "minimum": 0.1, "maximum": 2.5}},
+		{"float64", []filter.Filter{filter.Float64().Min(-1.5)},
+			map[string]interface{}{"type": "number", "format": "double", "minimum": -1.5}},
+		{"string", []filter.Filter{filter.String().MinLen(2).MaxLen(20)},
+			map[string]interface{}{"type": "string", "minLength": 2, "maxLength": 20}},
+		{"string set", []filter.Filter{filter.StringSet().ItemMaxLen(8)},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "maxLength": 8}}},
+		{"int set", []filter.Filter{filter.IntSet().ItemMin(1)},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer", "format": "int32", "minimum": int64(1)}}},
+		{"date", []filter.Filter{filter.Time().StartFrom("2020-01-01")},
+			map[string]interface{}{"type": "string", "format": "date", "x-layout": "2006-01-02"}},
+		{"datetime", []filter.Filter{filter.Time().HasTime()},
+			map[string]interface{}{"type": "string", "x-layout": "2006-01-02 15:04:05"}},
+		{"rfc3339", []filter.Filter{filter.Time().Layout("2006-01-02T15:04:05Z07:00")},
+			map[string]interface{}{"type": "string", "format": "date-time", "x-layout": "2006-01-02T15:04:05Z07:00"}},
+	}
+	for _, c := range cases {
+		got, _, _ := paramSchema(c.filters, nil)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: paramSchema() = %#v, want %#v", c.name, got, c.want)
+		}
+	}
+
+	_, required, _ := paramSchema([]filter.Filter{filter.Required(), filter.String()}, nil)
+	if !required {
+		t.Error("param with Required filter should be required")
+	}
+}
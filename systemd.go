@@ -0,0 +1,125 @@
+// systemd socket activation
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd 传递的 fd 从 3 开始
+const systemdListenFdsStart = 3
+
+type systemdListener struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdMutex     sync.Mutex
+	systemdListeners []*systemdListener
+	systemdActivated bool // 是否由 systemd 传递了监听socket
+)
+
+// hasInheritedListener 判断是否有平滑重启或 systemd 传递的监听socket
+func hasInheritedListener() bool {
+	if os.Getenv("APP_LISTEN_FDS") != "" {
+		return true
+	}
+	loadSystemdListeners()
+	systemdMutex.Lock()
+	defer systemdMutex.Unlock()
+	return len(systemdListeners) > 0
+}
+
+// loadSystemdListeners 读取 LISTEN_PID/LISTEN_FDS/LISTEN_FDNAMES，只在进程中读取一次
+// REF: https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+func loadSystemdListeners() {
+	systemdOnce.Do(func() {
+		envPid := os.Getenv("LISTEN_PID")
+		envFds := os.Getenv("LISTEN_FDS")
+		envNames := os.Getenv("LISTEN_FDNAMES")
+		// 读取完立即清空，防止被子进程继承
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		if envPid == "" || envFds == "" {
+			return
+		}
+		if pid, err := strconv.Atoi(envPid); err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(envFds)
+		if err != nil || n <= 0 {
+			return
+		}
+		var names []string
+		if envNames != "" {
+			names = strings.Split(envNames, ":")
+		}
+
+		for i := 0; i < n; i++ {
+			name := "unknown"
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(systemdListenFdsStart+i), name)
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				// 非监听socket（如 datagram），忽略
+				continue
+			}
+			systemdListeners = append(systemdListeners, &systemdListener{name, ln})
+		}
+		systemdActivated = len(systemdListeners) > 0
+	})
+}
+
+// takeSystemdListener 取出 systemd 传递的监听socket，没有传递socket时返回nil
+// name 不为空时按名称匹配，否则按监听地址匹配，都不匹配时返回错误
+// 同一socket只能被取出一次
+func takeSystemdListener(name string, network, addr string) (net.Listener, error) {
+	loadSystemdListeners()
+
+	systemdMutex.Lock()
+	defer systemdMutex.Unlock()
+	if !systemdActivated {
+		return nil, nil
+	}
+	for i, sl := range systemdListeners {
+		if (name != "" && sl.name == name) || (name == "" && systemdAddrMatch(sl.ln.Addr(), network, addr)) {
+			systemdListeners = append(systemdListeners[:i], systemdListeners[i+1:]...)
+			return sl.ln, nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("systemd socket %s not found", name)
+	}
+	return nil, fmt.Errorf("no systemd socket matches address %s", addr)
+}
+
+// systemdAddrMatch 判断 systemd socket 的地址是否与监听地址一致，监听地址未指定IP时匹配任意地址
+func systemdAddrMatch(lnAddr net.Addr, network, addr string) bool {
+	if network == "unix" {
+		return lnAddr.Network() == "unix" && lnAddr.String() == addr
+	}
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return false
+	}
+	lnTcpAddr, ok := lnAddr.(*net.TCPAddr)
+	if !ok || lnTcpAddr.Port != tcpAddr.Port {
+		return false
+	}
+	if tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return lnTcpAddr.IP == nil || lnTcpAddr.IP.IsUnspecified()
+	}
+	return lnTcpAddr.IP.Equal(tcpAddr.IP)
+}
@@ -0,0 +1,166 @@
+// TLS配置
+
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLS 安全级别，参考：https://wiki.mozilla.org/Security/Server_Side_TLS
+//
+//	modern: 只支持 TLS 1.3
+//	intermediate: TLS 1.2+，只使用 ECDHE + AEAD 加密套件（默认）
+//	legacy: TLS 1.0+，兼容旧客户端
+type tlsProfile struct {
+	minVersion   uint16
+	cipherSuites []uint16 // 只对 TLS 1.2 及以下版本有效，TLS 1.3 加密套件不可配置
+}
+
+var tlsProfiles = map[string]tlsProfile{
+	"modern": {tls.VersionTLS13, nil},
+	"intermediate": {tls.VersionTLS12, []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	}},
+	"legacy": {tls.VersionTLS10, []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	}},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig return the tls config of listener according to app.tls config section:
+//
+//	app.tls.profile: modern, intermediate or legacy, default is intermediate
+//	app.tls.min_version: 1.0, 1.1, 1.2 or 1.3, override the min version of profile
+//	app.tls.ciphers: cipher suite names, override the cipher suites of profile
+//	app.tls.http2: enable HTTP/2 by ALPN, default is true
+//	app.tls.client_auth: none, require or verify_if_given, default is none
+//	app.tls.client_ca: PEM file of client CA certificates, local means the CA generated by app.tls.auto_cert.ca
+//	app.tls.certs: certificates selected by SNI server name, see getSNICerts
+func (app *App) NewTLSConfig(define *ListenerDefine) (*tls.Config, error) {
+	cfg := app.Config
+
+	profileName := cfg.GetDefaultString("app.tls.profile", "intermediate")
+	profile, ok := tlsProfiles[profileName]
+	if !ok {
+		return nil, fmt.Errorf("unknown tls profile: %s", profileName)
+	}
+
+	config := &tls.Config{
+		MinVersion:   profile.minVersion,
+		CipherSuites: profile.cipherSuites,
+	}
+
+	if minVersion := cfg.GetDefaultString("app.tls.min_version", ""); minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls version: %s", minVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if cipherNames := cfg.GetDefaultStringArray("app.tls.ciphers", nil); len(cipherNames) > 0 {
+		cipherSuites, err := parseCipherSuites(cipherNames)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = cipherSuites
+	}
+
+	if cfg.GetDefaultBool("app.tls.http2", true) {
+		config.NextProtos = []string{"h2", "http/1.1"}
+		if !http2CipherSuitesOk(config) {
+			app.Logger.Warning("(api) http2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, disable http2.")
+			config.NextProtos = []string{"http/1.1"}
+		}
+	} else {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	if err := app.setClientAuth(config); err != nil {
+		return nil, err
+	}
+
+	// 配置的证书支持热加载，未配置时自动生成证书
+	if reloader := app.getCertReloader(); reloader != nil {
+		config.GetCertificate = reloader.GetCertificate
+	} else {
+		// 自动生成的证书即将过期时重新生成
+		autoReloader, err := newAutoCertReloader(app, define)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = autoReloader.GetCertificate
+	}
+
+	// 按SNI选择证书，没有匹配时使用以上证书
+	sniCerts, err := app.getSNICerts()
+	if err != nil {
+		return nil, err
+	}
+	if len(sniCerts) > 0 {
+		config.GetCertificate = sniGetCertificate(sniCerts, config.GetCertificate)
+	}
+
+	return config, nil
+}
+
+// parseCipherSuites 将加密套件名称转换为ID，如：TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+func parseCipherSuites(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// http2CipherSuitesOk 检查加密套件是否满足 HTTP/2 的要求
+func http2CipherSuitesOk(config *tls.Config) bool {
+	if config.CipherSuites == nil || config.MinVersion >= tls.VersionTLS13 {
+		return true
+	}
+	for _, id := range config.CipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+// 追踪数据输出
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONSpanExporter write each span as one line of json.
+type JSONSpanExporter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewJSONSpanExporter return an exporter writing spans to w, w is closed when shutdown if it is an io.Closer
+// other than stdout and stderr.
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{w: w}
+}
+
+// ExportSpans implements SpanExporter.
+func (e *JSONSpanExporter) ExportSpans(spans []*Span) error {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown implements SpanExporter.
+func (e *JSONSpanExporter) Shutdown(ctx context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if f, ok := e.w.(*os.File); ok && (f == os.Stdout || f == os.Stderr) {
+		return nil
+	}
+	if closer, ok := e.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// openTraceFile 以追加方式打开文件，相对路径相对于程序所在目录
+func openTraceFile(path string) (*os.File, error) {
+	if !filepath.IsAbs(path) {
+		progDir := filepath.Dir(os.Args[0])
+		path = filepath.Join(progDir, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// OTLPSpanExporter send spans to OpenTelemetry collector in OTLP/HTTP json encoding.
+type OTLPSpanExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+}
+
+// NewOTLPSpanExporter return an exporter sending spans to endpoint, such as http://localhost:4318/v1/traces.
+func NewOTLPSpanExporter(endpoint, serviceName string, headers map[string]string) *OTLPSpanExporter {
+	return &OTLPSpanExporter{endpoint, serviceName, headers, &http.Client{Timeout: 10 * time.Second}}
+}
+
+// OTLP json 编码，参考：https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// ExportSpans implements SpanExporter.
+func (e *OTLPSpanExporter) ExportSpans(spans []*Span) error {
+	otlpSpans := make([]*otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mutex.Lock()
+		span := &otlpSpan{
+			TraceId:           s.TraceId,
+			SpanId:            s.SpanId,
+			ParentSpanId:      s.ParentSpanId,
+			Name:              s.Name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{1, ""}, // STATUS_CODE_OK
+		}
+		if s.Kind == "server" {
+			span.Kind = 2 // SPAN_KIND_SERVER
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{2, s.Error} // STATUS_CODE_ERROR
+		}
+		s.mutex.Unlock()
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/go-apibox/api"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector returned %s: %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+// Shutdown implements SpanExporter.
+func (e *OTLPSpanExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// otlpAttributes 转换属性，整数按规范编码为字符串
+func otlpAttributes(attrs map[string]interface{}) []*otlpKeyValue {
+	kvs := make([]*otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch vv := v.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": vv}
+		case bool:
+			value = map[string]interface{}{"boolValue": vv}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(int64(vv), 10)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(vv, 10)}
+		case uint32:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(uint64(vv), 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": vv}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(vv)}
+		}
+		kvs = append(kvs, &otlpKeyValue{k, value})
+	}
+	return kvs
+}
@@ -0,0 +1,416 @@
+// 请求追踪
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	gcontext "github.com/gorilla/context"
+	"xorm.io/xorm/contexts"
+)
+
+// Span 请求中的一段操作，如：请求、hook、action、数据库操作
+// 未启用追踪或请求未被采样时为nil，nil的 Span 所有方法均可调用
+type Span struct {
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+	Name         string
+	Kind         string // server 或 internal
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Error        string // 非空表示失败
+
+	tracer *tracer
+	mutex  sync.Mutex
+	ended  bool
+}
+
+// SetAttribute set an attribute of span, value should be string, bool, integer or float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.Attributes[key] = value
+	s.mutex.Unlock()
+}
+
+// SetError mark the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.Error = message
+	s.mutex.Unlock()
+}
+
+// End finish the span and send it to exporter, calling End more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// setResult 根据接口返回值记录错误码
+func (s *Span) setResult(data interface{}) {
+	if s == nil {
+		return
+	}
+	var code string
+	switch v := data.(type) {
+	case *Error:
+		code = v.Code
+	case Error:
+		code = v.Code
+	default:
+		return
+	}
+	s.SetAttribute("api.code", code)
+	s.SetError(code)
+}
+
+// MarshalJSON 输出为JSON格式，时间为 RFC3339 格式
+func (s *Span) MarshalJSON() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return json.Marshal(struct {
+		TraceId      string                 `json:"trace_id"`
+		SpanId       string                 `json:"span_id"`
+		ParentSpanId string                 `json:"parent_span_id,omitempty"`
+		Name         string                 `json:"name"`
+		Kind         string                 `json:"kind"`
+		StartTime    string                 `json:"start_time"`
+		Duration     float64                `json:"duration_ms"`
+		Attributes   map[string]interface{} `json:"attributes,omitempty"`
+		Error        string                 `json:"error,omitempty"`
+	}{
+		s.TraceId, s.SpanId, s.ParentSpanId, s.Name, s.Kind,
+		s.StartTime.Format(time.RFC3339Nano),
+		float64(s.EndTime.Sub(s.StartTime).Microseconds()) / 1000,
+		s.Attributes, s.Error,
+	})
+}
+
+// newChildSpan 创建子 Span
+func (s *Span) newChildSpan(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{
+		TraceId:      s.TraceId,
+		SpanId:       newSpanId(),
+		ParentSpanId: s.SpanId,
+		Name:         name,
+		Kind:         "internal",
+		StartTime:    time.Now(),
+		Attributes:   map[string]interface{}{},
+		tracer:       s.tracer,
+	}
+}
+
+// SpanExporter export finished spans, such as writing to file or sending to collector.
+type SpanExporter interface {
+	// ExportSpans export a batch of spans, it is called in one goroutine.
+	ExportSpans(spans []*Span) error
+	// Shutdown flush and close the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// SetSpanExporter set the exporter of tracing spans, it overrides api.tracing.exporter config.
+func (app *App) SetSpanExporter(exporter SpanExporter) {
+	app.spanExporter = exporter
+}
+
+// tracer 收集已结束的 Span，按批发送给 exporter
+type tracer struct {
+	app        *App
+	exporter   SpanExporter
+	sampleRate float64
+	batchSize  int
+	interval   time.Duration
+
+	queue   chan *Span
+	stop    chan bool
+	stopped chan bool
+	once    sync.Once
+}
+
+// initTracing 初始化追踪，配置项：
+//
+//	api.tracing.enabled: 是否启用，默认为 false
+//	api.tracing.exporter: stdout, file 或 otlp，默认为 stdout
+//	api.tracing.file: exporter 为 file 时的文件路径，默认为 logs/trace.log
+//	api.tracing.otlp.endpoint: OTLP/HTTP 接收地址，默认为 http://localhost:4318/v1/traces
+//	api.tracing.otlp.headers: 发送时附加的HTTP头
+//	api.tracing.sample_rate: 新追踪的采样率，默认为 1，上游传递的追踪按其采样标志
+//	api.tracing.batch_size: 每批发送的数量，默认为 100
+//	api.tracing.flush_interval: 最长发送间隔，默认为 5s
+//	api.tracing.queue_size: 待发送队列长度，队列满时丢弃，默认为 2048
+func (app *App) initTracing() error {
+	cfg := app.Config
+	if !cfg.GetDefaultBool("api.tracing.enabled", false) {
+		return nil
+	}
+
+	exporter := app.spanExporter
+	if exporter == nil {
+		var err error
+		exporter, err = app.newSpanExporter()
+		if err != nil {
+			return err
+		}
+	}
+
+	batchSize := cfg.GetDefaultInt("api.tracing.batch_size", 100)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	interval := getConfigDuration(cfg, "api.tracing.flush_interval", 5*time.Second)
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	t := &tracer{
+		app:        app,
+		exporter:   exporter,
+		sampleRate: cfg.GetDefaultFloat("api.tracing.sample_rate", 1),
+		batchSize:  batchSize,
+		interval:   interval,
+		queue:      make(chan *Span, cfg.GetDefaultInt("api.tracing.queue_size", 2048)),
+		stop:       make(chan bool),
+		stopped:    make(chan bool),
+	}
+	app.tracer = t
+	go t.run()
+	app.OnShutdown(t.shutdown)
+	return nil
+}
+
+// newSpanExporter 根据配置创建 exporter
+func (app *App) newSpanExporter() (SpanExporter, error) {
+	cfg := app.Config
+	switch name := cfg.GetDefaultString("api.tracing.exporter", "stdout"); name {
+	case "stdout":
+		return NewJSONSpanExporter(os.Stdout), nil
+	case "file":
+		f, err := openTraceFile(cfg.GetDefaultString("api.tracing.file", "logs/trace.log"))
+		if err != nil {
+			return nil, err
+		}
+		return NewJSONSpanExporter(f), nil
+	case "otlp":
+		endpoint := cfg.GetDefaultString("api.tracing.otlp.endpoint", "http://localhost:4318/v1/traces")
+		headers := map[string]string{}
+		for k, v := range cfg.GetDefaultMap("api.tracing.otlp.headers", nil) {
+			headers[k] = fmt.Sprint(v)
+		}
+		return NewOTLPSpanExporter(endpoint, app.Name, headers), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", name)
+	}
+}
+
+// startRequestSpan 开始请求的根 Span，未启用追踪或未被采样时返回nil
+// 根 Span 的ID与向下游传递的 traceparent 一致
+func (t *tracer) startRequestSpan(r *http.Request, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	rt := getRequestTrace(r)
+	if rt == nil {
+		return nil
+	}
+
+	if rt.parentSpanId != "" {
+		// 上游传递的追踪按其采样标志
+		flags, _ := strconv.ParseUint(rt.flags, 16, 8)
+		if flags&1 == 0 {
+			return nil
+		}
+	} else if rand.Float64() >= t.sampleRate {
+		rt.flags = "00"
+		return nil
+	}
+
+	span := &Span{
+		TraceId:      rt.traceId,
+		SpanId:       rt.spanId,
+		ParentSpanId: rt.parentSpanId,
+		Name:         name,
+		Kind:         "server",
+		StartTime:    time.Now(),
+		Attributes: map[string]interface{}{
+			"http.method":    r.Method,
+			"http.target":    r.URL.Path,
+			"api.request_id": rt.requestId,
+		},
+		tracer: t,
+	}
+	gcontext.Set(r, "trace_span", span)
+	return span
+}
+
+// getCurrentSpan 返回请求当前的 Span
+func getCurrentSpan(r *http.Request) *Span {
+	span, _ := gcontext.Get(r, "trace_span").(*Span)
+	return span
+}
+
+// enqueue 加入发送队列，已关闭或队列满时丢弃
+func (t *tracer) enqueue(s *Span) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	select {
+	case t.queue <- s:
+	default:
+	}
+}
+
+func (t *tracer) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			t.app.Logger.Warningf("(api) export spans failed: %s", err.Error())
+		}
+		batch = make([]*Span, 0, t.batchSize)
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= t.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			// 发送队列中剩余的 Span
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					if len(batch) >= t.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					close(t.stopped)
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown 发送剩余的 Span 并关闭 exporter
+func (t *tracer) shutdown(ctx context.Context) {
+	t.once.Do(func() {
+		close(t.stop)
+		select {
+		case <-t.stopped:
+		case <-ctx.Done():
+		}
+		if err := t.exporter.Shutdown(ctx); err != nil {
+			t.app.Logger.Warningf("(api) shutdown span exporter failed: %s", err.Error())
+		}
+	})
+}
+
+// StartSpan start a child span of current span, it returns nil if the request is not traced.
+// The span must be ended by calling End.
+func (c *Context) StartSpan(name string) *Span {
+	return c.currentSpan().newChildSpan(name)
+}
+
+// currentSpan 返回当前的 Span
+func (c *Context) currentSpan() *Span {
+	return getCurrentSpan(c.Input.Request)
+}
+
+// enterSpan 开始子 Span 并作为当前 Span，返回的函数根据结果记录错误、结束该 Span 并恢复原 Span
+func (c *Context) enterSpan(name string) (*Span, func(result interface{})) {
+	parent := c.currentSpan()
+	span := parent.newChildSpan(name)
+	if span == nil {
+		return nil, func(interface{}) {}
+	}
+
+	c.Set("trace_span", span)
+	return span, func(result interface{}) {
+		c.Set("trace_span", parent)
+		span.setResult(result)
+		span.End()
+	}
+}
+
+// runInSpan 在子 Span 中运行，运行期间子 Span 作为当前 Span
+func (c *Context) runInSpan(name string, f func() interface{}) (data interface{}) {
+	_, end := c.enterSpan(name)
+	defer func() { end(data) }()
+	return f()
+}
+
+// traceContextKey 数据库会话 context 中保存 *Context 的键
+type traceContextKey struct{}
+
+// sqlTraceHook 为每条SQL语句创建当前 Span 的子 Span，由 DbManager 添加到数据库引擎
+type sqlTraceHook struct{}
+
+func (sqlTraceHook) BeforeProcess(hc *contexts.ContextHook) (context.Context, error) {
+	return hc.Ctx, nil
+}
+
+func (sqlTraceHook) AfterProcess(hc *contexts.ContextHook) error {
+	if hc.Ctx == nil {
+		return nil
+	}
+	c, _ := hc.Ctx.Value(traceContextKey{}).(*Context)
+	if c == nil {
+		return nil
+	}
+	span := c.StartSpan("sql")
+	if span == nil {
+		return nil
+	}
+	span.StartTime = span.StartTime.Add(-hc.ExecuteTime)
+	span.SetAttribute("db.statement", hc.SQL)
+	if hc.Result != nil {
+		if affected, err := hc.Result.RowsAffected(); err == nil {
+			span.SetAttribute("db.rows_affected", affected)
+		}
+	}
+	if hc.Err != nil {
+		span.SetError(hc.Err.Error())
+	}
+	span.End()
+	return nil
+}
@@ -0,0 +1,450 @@
+// 文件上传
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-apibox/config"
+	"github.com/go-apibox/filter"
+	"github.com/go-apibox/utils"
+)
+
+// UploadFile is a file uploaded with multipart request.
+// Small files are kept in memory, large files are spooled to temp directory
+// and will be removed after the request.
+type UploadFile struct {
+	FieldName string
+	Filename  string
+	Header    textproto.MIMEHeader
+	Size      int64
+	content   []byte
+	tmpFile   string
+}
+
+// UploadLimit define the size limits of multipart request.
+// Zero value means no limit.
+type UploadLimit struct {
+	MaxSize     int64 // 请求中所有参数及文件的总大小
+	MaxFileSize int64 // 单个文件的大小
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (rc sectionReadCloser) Close() error {
+	return nil
+}
+
+// Open return a reader of the uploaded file content.
+func (f *UploadFile) Open() (multipart.File, error) {
+	if f.tmpFile != "" {
+		return os.Open(f.tmpFile)
+	}
+	r := io.NewSectionReader(bytes.NewReader(f.content), 0, int64(len(f.content)))
+	return sectionReadCloser{r}, nil
+}
+
+// ContentType return the content type declared by client.
+func (f *UploadFile) ContentType() string {
+	return f.Header.Get("Content-Type")
+}
+
+// DetectContentType return the content type detected from file content.
+func (f *UploadFile) DetectContentType() (string, error) {
+	file, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// SaveTo save the uploaded file to specified path.
+func (f *UploadFile) SaveTo(path string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// uploadLimitReader 记录已读取的字节数，超出限制时返回错误
+type uploadLimitReader struct {
+	r        io.Reader
+	n        int64
+	max      int64
+	exceeded bool
+}
+
+var errUploadTooLarge = errors.New("upload size exceeded")
+
+func (lr *uploadLimitReader) Read(p []byte) (int, error) {
+	if lr.max > 0 && lr.n >= lr.max {
+		// 刚好读取到限制大小时，检查是否还有剩余内容
+		var b [1]byte
+		if n, err := lr.r.Read(b[:]); n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		lr.exceeded = true
+		return 0, errUploadTooLarge
+	}
+	if lr.max > 0 && int64(len(p)) > lr.max-lr.n {
+		p = p[:lr.max-lr.n]
+	}
+	n, err := lr.r.Read(p)
+	lr.n += int64(n)
+	return n, err
+}
+
+// isMultipartRequest 判断是否为 multipart/form-data 请求
+func isMultipartRequest(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "multipart/form-data"
+}
+
+// getConfigBytes 读取大小配置，支持整数或带单位的字符串，如：10MB
+func getConfigBytes(cfg *config.Config, key string, defaultVal int64) int64 {
+	if v, err := cfg.GetInt(key); err == nil {
+		return int64(v)
+	}
+	if v, err := cfg.GetString(key); err == nil {
+		if size, err := utils.ParseBytes(v); err == nil {
+			return int64(size)
+		}
+	}
+	return defaultVal
+}
+
+// uploadLimit 返回指定 action 的上传限制
+// action 的限制不能超过全局限制
+func (app *App) uploadLimit(action string) UploadLimit {
+	limit := UploadLimit{
+		MaxSize:     getConfigBytes(app.Config, "api.upload.max_size", 32<<20),
+		MaxFileSize: getConfigBytes(app.Config, "api.upload.max_file_size", 0),
+	}
+	for _, route := range app.Routes {
+		if route.ActionCode == action && route.UploadLimit != nil {
+			limit = limit.merge(*route.UploadLimit)
+			break
+		}
+	}
+	return limit
+}
+
+// merge 合并两个限制，取更严格的值
+func (l UploadLimit) merge(other UploadLimit) UploadLimit {
+	if other.MaxSize > 0 && (l.MaxSize == 0 || other.MaxSize < l.MaxSize) {
+		l.MaxSize = other.MaxSize
+	}
+	if other.MaxFileSize > 0 && (l.MaxFileSize == 0 || other.MaxFileSize < l.MaxFileSize) {
+		l.MaxFileSize = other.MaxFileSize
+	}
+	return l
+}
+
+// check 检查已解析的上传内容是否超出限制
+func (l UploadLimit) check(i *Input) *filter.Error {
+	if l.MaxSize > 0 && i.uploadSize > l.MaxSize {
+		return filter.NewError(filter.ErrorQuotaExceed, "UploadSize")
+	}
+	if l.MaxFileSize > 0 {
+		for fieldName, files := range i.files {
+			for _, file := range files {
+				if file.Size > l.MaxFileSize {
+					return filter.NewError(filter.ErrorInvalidParam, fieldName, "TooLarge")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseMultipartBody 解析 multipart 请求体
+// 普通参数合并到 Request.Form 中，文件内容超过 memSize 时写入临时目录
+// URL中未指定 api_action 时，读取到请求体中的 api_action 后使用 actionLimit 返回的该action的限制，
+// 之前已读取的内容由 Route.UploadLimit 在解析后检查
+func (i *Input) parseMultipartBody(limit UploadLimit, actionLimit func(action string) UploadLimit,
+	memSize int64, tempDir string) error {
+	r := i.Request
+	urlHasAction := r.URL.Query().Get("api_action") != ""
+	body := &uploadLimitReader{r: r.Body, max: limit.MaxSize}
+	r.Body = ioutil.NopCloser(body)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	if r.PostForm == nil {
+		r.PostForm = make(url.Values)
+	}
+	i.files = make(map[string][]*UploadFile)
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if body.exceeded {
+				i.uploadErr = filter.NewError(filter.ErrorQuotaExceed, "UploadSize")
+				return nil
+			}
+			return err
+		}
+
+		fieldName := part.FormName()
+		if fieldName == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			// 普通参数
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, part)
+			i.uploadSize += n
+			if err != nil {
+				if body.exceeded {
+					i.uploadErr = filter.NewError(filter.ErrorQuotaExceed, "UploadSize")
+					return nil
+				}
+				return err
+			}
+			r.PostForm[fieldName] = append(r.PostForm[fieldName], buf.String())
+			r.Form[fieldName] = append(r.Form[fieldName], buf.String())
+			if fieldName == "api_action" && !urlHasAction && len(r.PostForm[fieldName]) == 1 {
+				limit = limit.merge(actionLimit(buf.String()))
+				body.max = limit.MaxSize
+			}
+			continue
+		}
+
+		// 先登记文件，确保出错时临时文件也能被清理
+		file := &UploadFile{
+			FieldName: fieldName,
+			Filename:  filepath.Base(part.FileName()),
+			Header:    part.Header,
+		}
+		i.files[fieldName] = append(i.files[fieldName], file)
+
+		err = file.spool(part, limit.MaxFileSize, memSize, tempDir)
+		i.uploadSize += file.Size
+		if err != nil {
+			if body.exceeded {
+				i.uploadErr = filter.NewError(filter.ErrorQuotaExceed, "UploadSize")
+				return nil
+			}
+			if err == errUploadTooLarge {
+				i.uploadErr = filter.NewError(filter.ErrorInvalidParam, fieldName, "TooLarge")
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// spool 读取文件内容，超过 memSize 时写入临时文件
+func (f *UploadFile) spool(r io.Reader, maxSize, memSize int64, tempDir string) error {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, memSize+1))
+	f.Size = n
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && f.Size > maxSize {
+		return errUploadTooLarge
+	}
+	if n <= memSize {
+		f.content = buf.Bytes()
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(tempDir, "apibox-upload-")
+	if err != nil {
+		return err
+	}
+	f.tmpFile = tmp.Name()
+	defer tmp.Close()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	var src io.Reader = r
+	if maxSize > 0 {
+		src = io.LimitReader(r, maxSize-f.Size+1)
+	}
+	n, err = io.Copy(tmp, src)
+	f.Size += n
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && f.Size > maxSize {
+		return errUploadTooLarge
+	}
+	return nil
+}
+
+// File return the first uploaded file of the field.
+func (i *Input) File(fieldName string) *UploadFile {
+	files := i.files[fieldName]
+	if len(files) == 0 {
+		return nil
+	}
+	return files[0]
+}
+
+// Files return all uploaded files of the field.
+func (i *Input) Files(fieldName string) []*UploadFile {
+	return i.files[fieldName]
+}
+
+// RemoveFiles remove temp files of uploaded files.
+// It is called automatically after the request.
+func (i *Input) RemoveFiles() error {
+	var lastErr error
+	for _, files := range i.files {
+		for _, file := range files {
+			if file.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(file.tmpFile); err != nil && !os.IsNotExist(err) {
+				lastErr = err
+			}
+			file.tmpFile = ""
+			file.content = nil
+		}
+	}
+	return lastErr
+}
+
+// FileFilter validates uploaded files.
+type FileFilter struct {
+	minSize int64
+	maxSize int64
+	types   []string
+}
+
+// File return a file filter.
+func File() *FileFilter {
+	return new(FileFilter)
+}
+
+// MinSize valid file size should not be smaller than the specified size.
+func (f *FileFilter) MinSize(size int64) *FileFilter {
+	f.minSize = size
+	return f
+}
+
+// MaxSize valid file size should not be larger than the specified size.
+func (f *FileFilter) MaxSize(size int64) *FileFilter {
+	f.maxSize = size
+	return f
+}
+
+// Types valid file type should in the specified list.
+// Type can be a mime type (image/png), a mime type group (image/*), or an extension (.png).
+// Mime type is detected from file content instead of the Content-Type sent by client.
+func (f *FileFilter) Types(types ...string) *FileFilter {
+	for _, t := range types {
+		f.types = append(f.types, strings.ToLower(t))
+	}
+	return f
+}
+
+// Run make the filter running.
+func (f *FileFilter) Run(paramName string, paramValue interface{}) (interface{}, *filter.Error) {
+	switch v := paramValue.(type) {
+	case nil:
+		return nil, nil
+	case *UploadFile:
+		if err := f.check(paramName, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case []*UploadFile:
+		for _, file := range v {
+			if err := f.check(paramName, file); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	return nil, filter.NewError(filter.ErrorInvalidParam, paramName, "NotFile")
+}
+
+func (f *FileFilter) check(paramName string, file *UploadFile) *filter.Error {
+	if f.minSize > 0 && file.Size < f.minSize {
+		return filter.NewError(filter.ErrorInvalidParam, paramName, "TooSmall")
+	}
+	if f.maxSize > 0 && file.Size > f.maxSize {
+		return filter.NewError(filter.ErrorInvalidParam, paramName, "TooLarge")
+	}
+	if len(f.types) == 0 {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	contentType, err := file.DetectContentType()
+	if err != nil {
+		return filter.NewError(filter.ErrorInvalidParam, paramName, "NotFile")
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	for _, t := range f.types {
+		switch {
+		case strings.HasPrefix(t, "."):
+			if t == ext {
+				return nil
+			}
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mediaType, t[:len(t)-1]) {
+				return nil
+			}
+		default:
+			if t == mediaType {
+				return nil
+			}
+		}
+	}
+	return filter.NewError(filter.ErrorInvalidParam, paramName, "WrongFileType")
+}